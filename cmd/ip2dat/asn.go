@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 
+	"github.com/billcoding/ip2dat/datfile"
 	"github.com/billcoding/ip2dat/ip2asn"
 	"github.com/billcoding/ip2dat/ipasnsearch"
 	"github.com/spf13/cobra"
@@ -11,11 +12,25 @@ import (
 var asnCmd = &cobra.Command{
 	Use:     "asn",
 	Aliases: []string{},
-	Short:   "Convertor IP asn from TXT or CSV to .dat.",
-	Long:    `Convertor IP asn from TXT or CSV to .dat.`,
-	Example: `ip2dat asn -i /to/path/ip2asn.txt -o /to/path/ip2asn.dat`,
+	Short:   "Convertor IP asn from TXT, CSV or MMDB to .dat.",
+	Long:    `Convertor IP asn from TXT, CSV or MMDB to .dat.`,
+	Example: `ip2dat asn -i /to/path/ip2asn.txt -o /to/path/ip2asn.dat
+ip2dat asn -i GeoLite2-ASN.mmdb -o /to/path/ip2asn.dat`,
 	Run: func(_ *cobra.Command, _ []string) {
-		_ = ip2asn.Convert(asnInputFile, asnOutputFile)
+		opts := []datfile.BuilderOption{datfile.WithMaxMemory(asnMaxMemory << 20)}
+		if asnCompress {
+			opts = append(opts, datfile.WithCompression())
+		}
+		columns, err := columnMapping(asnColumns, asnSchema)
+		if err != nil {
+			fmt.Println("列映射错误:", err)
+			return
+		}
+		if isMMDB(asnInputFile) {
+			_ = ip2asn.ConvertMMDB(asnInputFile, asnOutputFile, columns, opts...)
+		} else {
+			_ = ip2asn.ConvertColumns(asnInputFile, asnOutputFile, columns, opts...)
+		}
 		if asnTest && asnTestIp != "" {
 			fmt.Println(asnTestIp + " asn: " + ipasnsearch.Search(asnOutputFile, asnTestIp))
 		}
@@ -27,6 +42,10 @@ var (
 	asnOutputFile string
 	asnTest       bool
 	asnTestIp     string
+	asnMaxMemory  int64
+	asnCompress   bool
+	asnColumns    string
+	asnSchema     string
 )
 
 func init() {
@@ -34,5 +53,9 @@ func init() {
 	asnCmd.PersistentFlags().StringVarP(&asnOutputFile, "output", "o", "ip2asn.dat", "The ip2asn output file path")
 	asnCmd.PersistentFlags().BoolVarP(&asnTest, "test", "t", false, "Test after converted")
 	asnCmd.PersistentFlags().StringVar(&asnTestIp, "test-ip", "1.1.1.1", "Test ip address")
+	asnCmd.PersistentFlags().Int64Var(&asnMaxMemory, "max-memory", 0, "Memory budget in MB, spill sorted runs to temp files when exceeded (0 for unlimited)")
+	asnCmd.PersistentFlags().BoolVar(&asnCompress, "compress", false, "Compress the content area into independently compressed blocks")
+	asnCmd.PersistentFlags().StringVar(&asnColumns, "columns", "", "Column mapping such as start=1,end=ip_to,org=organization: 1-based numbers or header names (columns: start, end, range, asn, org); for .mmdb input, dot separated map paths such as asn=autonomous_system_number")
+	asnCmd.PersistentFlags().StringVar(&asnSchema, "schema", "", "Column mapping file with one name=column per line, overridden by --columns")
 	rootCmd.AddCommand(asnCmd)
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/billcoding/ip2dat/tabular"
+)
+
+// columnMapping 合并列映射文件和 --columns 指定的列映射，--columns 优先，都未指定时返回 nil
+func columnMapping(spec, file string) (tabular.Mapping, error) {
+	m := tabular.Mapping{}
+	if file != "" {
+		fm, err := tabular.LoadMapping(file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fm {
+			m[k] = v
+		}
+	}
+	sm, err := tabular.ParseMapping(spec)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range sm {
+		m[k] = v
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// isMMDB 按扩展名判断输入是否为 MaxMind DB 文件
+func isMMDB(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), ".mmdb")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/ip2asn"
+	"github.com/billcoding/ip2dat/ip2loc"
+	"github.com/spf13/cobra"
+)
+
+var dumpCmd = &cobra.Command{
+	Use:     "dump",
+	Aliases: []string{"d"},
+	Short:   "Export a .dat file back to TXT or CSV.",
+	Long: `Export every range of a .dat file back to the TXT or CSV layout accepted by the location and asn commands.
+The output is CSV when the output file ends with .csv, pipe-separated TXT otherwise.
+The dataset kind is read from the file header; use --kind for legacy files without one.
+Without --output the file is named after the kind: ip2loc.txt or ip2asn.txt.`,
+	Example: `ip2dat dump -i /to/path/ip2loc.dat -o /to/path/ip2loc.txt
+ip2dat dump -i /to/path/ip2asn.dat -o /to/path/ip2asn.csv`,
+	Args: cobra.NoArgs,
+
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		kind, err := datasetKind(dumpKindName, dumpInputFile)
+		if err != nil {
+			return err
+		}
+		output := dumpOutputFile
+		if output == "" {
+			output = defaultOutput(kind, ".txt")
+		}
+		switch kind {
+		case datfile.KindLocation:
+			return ip2loc.Dump(dumpInputFile, output)
+		case datfile.KindASN:
+			return ip2asn.Dump(dumpInputFile, output)
+		}
+		return fmt.Errorf("%s: 无法识别数据集类型，请用 --kind 指定 location 或 asn", dumpInputFile)
+	},
+}
+
+var (
+	dumpInputFile  string
+	dumpOutputFile string
+	dumpKindName   string
+)
+
+// datasetKind 返回 --kind 指定的数据集类型，未指定时从 .dat 文件头读取
+func datasetKind(name, datFile string) (datfile.Kind, error) {
+	switch name {
+	case "location", "loc":
+		return datfile.KindLocation, nil
+	case "asn":
+		return datfile.KindASN, nil
+	case "":
+	default:
+		return datfile.KindUnknown, fmt.Errorf("不支持的数据集类型: %s", name)
+	}
+	r, err := datfile.OpenMmap(datFile)
+	if err != nil {
+		return datfile.KindUnknown, fmt.Errorf("%s: %w", datFile, err)
+	}
+	defer r.Close()
+	return r.Header().Kind, nil
+}
+
+// defaultOutput 未指定输出文件时按数据集类型取文件名，无法识别的类型返回空
+func defaultOutput(kind datfile.Kind, ext string) string {
+	switch kind {
+	case datfile.KindLocation:
+		return "ip2loc" + ext
+	case datfile.KindASN:
+		return "ip2asn" + ext
+	}
+	return ""
+}
+
+func init() {
+	dumpCmd.PersistentFlags().StringVarP(&dumpInputFile, "input", "i", "ip2loc.dat", "The .dat input file path")
+	dumpCmd.PersistentFlags().StringVarP(&dumpOutputFile, "output", "o", "", "The TXT or CSV output file path (default ip2loc.txt or ip2asn.txt by kind)")
+	dumpCmd.PersistentFlags().StringVar(&dumpKindName, "kind", "", "Dataset kind for legacy files: location or asn")
+	rootCmd.AddCommand(dumpCmd)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/billcoding/ip2dat/ipasnsearch"
+	"github.com/billcoding/ip2dat/iplocsearch"
+	"github.com/spf13/cobra"
+)
+
+var enrichCmd = &cobra.Command{
+	Use:     "enrich",
+	Aliases: []string{"e"},
+	Short:   "Annotate access logs with location and ASN fields.",
+	Long: `Read common/combined log format or JSON lines from stdin and write each line to stdout
+with the client's country, city, ISP and ASN appended.
+
+Log lines are appended with four quoted fields ("country" "city" "isp" "asn", "-" when unknown);
+fields are numbered from 1, with quoted strings and [bracketed] times counting as one field.
+JSON lines get the keys country, city, isp and asn (with --key-prefix), replacing existing keys
+of the same name; missing values are omitted.
+
+When the X-Forwarded-For field is set, the client is the first address in it; with --trusted-proxy
+the header is only honoured when the peer is a known trusted proxy, and the client is the rightmost
+address that is not.`,
+	Example: `tail -f access.log | ip2dat enrich --loc ip2loc.dat --asn ip2asn.dat
+ip2dat enrich --loc ip2loc.dat --xff-field 10 --trusted-proxy 10.0.0.0/8 < access.log
+ip2dat enrich --asn ip2asn.dat --format json --ip-key client_ip --xff-key xff < access.json`,
+	Args: cobra.NoArgs,
+
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if enrichLocationFile == "" && enrichASNFile == "" {
+			return fmt.Errorf("至少需要指定 --loc 或 --asn")
+		}
+		switch enrichFormat {
+		case "auto", "clf", "json":
+		default:
+			return fmt.Errorf("不支持的日志格式: %s", enrichFormat)
+		}
+		if enrichIPField < 1 || enrichXFFField < 0 {
+			return fmt.Errorf("字段序号从 1 开始")
+		}
+		var trusted []netip.Prefix
+		for _, s := range enrichTrusted {
+			prefix, err := parseTrustedProxy(s)
+			if err != nil {
+				return err
+			}
+			trusted = append(trusted, prefix)
+		}
+
+		l, err := openLookuper(enrichLocationFile, enrichASNFile)
+		if err != nil {
+			return err
+		}
+		defer l.close()
+		e := &enricher{l: l, trusted: trusted}
+		return e.run(os.Stdin, os.Stdout)
+	},
+}
+
+var (
+	enrichLocationFile string
+	enrichASNFile      string
+	enrichFormat       string
+	enrichIPField      int
+	enrichXFFField     int
+	enrichIPKey        string
+	enrichXFFKey       string
+	enrichKeyPrefix    string
+	enrichTrusted      []string
+)
+
+// enricher 逐行给访问日志追加地理位置和 ASN 字段
+type enricher struct {
+	l       *lookuper
+	trusted []netip.Prefix
+	skipped int // 无法解析或没有有效 IP 的行数
+}
+
+// geoFields 追加到日志中的字段，未知为空
+type geoFields struct {
+	country, city, isp string
+	asn                uint32
+	routed             bool
+}
+
+func (e *enricher) run(in io.Reader, out io.Writer) error {
+	r := bufio.NewReaderSize(in, 1<<16)
+	w := bufio.NewWriterSize(out, 1<<16)
+	for {
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			enriched, err := e.enrich(line)
+			if err != nil {
+				_ = w.Flush()
+				return err
+			}
+			if _, err := w.Write(enriched); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			_ = w.Flush()
+			return fmt.Errorf("读取输入失败: %w", readErr)
+		}
+		// 输入暂时没有更多数据时立即输出，tail -f 时不会积压
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if e.skipped > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "%d 行无法解析或没有有效的客户端 IP\n", e.skipped)
+	}
+	return nil
+}
+
+// enrich 处理一行（包含换行符），返回追加字段后的行
+func (e *enricher) enrich(line []byte) ([]byte, error) {
+	body := bytes.TrimRight(line, "\r\n")
+	newline := line[len(body):]
+	if len(bytes.TrimSpace(body)) == 0 {
+		return line, nil
+	}
+	isJSON := enrichFormat == "json" || enrichFormat == "auto" && bytes.HasPrefix(bytes.TrimSpace(body), []byte("{"))
+	if isJSON {
+		return e.enrichJSON(body, newline)
+	}
+	return e.enrichCLF(body, newline)
+}
+
+func (e *enricher) enrichCLF(body, newline []byte) ([]byte, error) {
+	fields := splitLogFields(string(body))
+	var remote, xff string
+	if enrichIPField <= len(fields) {
+		remote = fields[enrichIPField-1]
+	}
+	if enrichXFFField > 0 && enrichXFFField <= len(fields) {
+		xff = fields[enrichXFFField-1]
+	}
+	g, err := e.lookup(remote, xff)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, body...)
+	values := []string{g.country, g.city, g.isp, ""}
+	if g.routed {
+		values[3] = strconv.FormatUint(uint64(g.asn), 10)
+	}
+	for _, v := range values {
+		if v == "" {
+			v = "-"
+		}
+		out = append(out, ' ')
+		out = strconv.AppendQuote(out, v)
+	}
+	return append(out, newline...), nil
+}
+
+// enrichJSON 在对象末尾追加字段，保留原有内容和键的顺序，已有的同名键被替换
+func (e *enricher) enrichJSON(body, newline []byte) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(body, &obj); err != nil || obj == nil {
+		e.skipped++
+		return append(body, newline...), nil
+	}
+	g, err := e.lookup(jsonString(obj[enrichIPKey]), jsonString(obj[enrichXFFKey]))
+	if err != nil {
+		return nil, err
+	}
+	var extra []byte
+	replaced := make(map[string]bool)
+	add := func(key string, value interface{}) {
+		key = enrichKeyPrefix + key
+		if _, ok := obj[key]; ok {
+			replaced[key] = true
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(value)
+		extra = append(extra, ',')
+		extra = append(extra, k...)
+		extra = append(extra, ':')
+		extra = append(extra, v...)
+	}
+	if g.country != "" {
+		add("country", g.country)
+	}
+	if g.city != "" {
+		add("city", g.city)
+	}
+	if g.isp != "" {
+		add("isp", g.isp)
+	}
+	if g.routed {
+		add("asn", g.asn)
+	}
+	if len(extra) == 0 {
+		return append(body, newline...), nil
+	}
+
+	if len(replaced) > 0 {
+		// 只有键重复时才重新生成对象，其余行原样保留
+		dropped, err := dropJSONKeys(body, replaced)
+		if err != nil {
+			e.skipped++
+			return append(body, newline...), nil
+		}
+		body = dropped
+	}
+	trimmed := bytes.TrimRight(body, " \t")
+	end := len(trimmed) - 1 // 已确认是合法的 JSON 对象，最后一个字符为 }
+	out := append([]byte{}, trimmed[:end]...)
+	if len(obj) == len(replaced) {
+		extra = extra[1:]
+	}
+	out = append(out, extra...)
+	out = append(out, '}')
+	return append(out, newline...), nil
+}
+
+// dropJSONKeys 删除对象中的指定键，保留其余键的顺序和值的原始内容
+func dropJSONKeys(body []byte, keys map[string]bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	out := []byte{'{'}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := t.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if keys[key] {
+			continue
+		}
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		k, _ := json.Marshal(key)
+		out = append(out, k...)
+		out = append(out, ':')
+		out = append(out, value...)
+	}
+	return append(out, '}'), nil
+}
+
+// lookup 确定客户端 IP 并查询，无效的 IP 不是错误，只有读取文件出错时返回错误
+func (e *enricher) lookup(remote, xff string) (geoFields, error) {
+	var g geoFields
+	addr, ok := clientIP(remote, xff, e.trusted)
+	if !ok {
+		e.skipped++
+		return g, nil
+	}
+	if e.l.loc != nil {
+		loc, err := e.l.loc.LookupAddr(addr)
+		switch {
+		case err == nil:
+			g.country, g.city, g.isp = loc.Country, loc.City, loc.ISP
+		case !errors.Is(err, iplocsearch.ErrNotFound):
+			return g, err
+		}
+	}
+	if e.l.asn != nil {
+		text, err := e.l.asn.FindAddr(addr)
+		switch {
+		case err == nil:
+			// 内容无法解析时不输出 ASN，不中断处理
+			if rec, err := ipasnsearch.ParseASNRecord(text); err == nil {
+				g.asn, g.routed = rec.ASN, rec.Routed
+			}
+		case !errors.Is(err, ipasnsearch.ErrNotFound):
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// clientIP 由对端地址和 X-Forwarded-For 确定客户端 IP。
+// 没有可信代理列表时取 X-Forwarded-For 中第一个有效地址；
+// 有可信代理列表时，只有对端是可信代理才使用 X-Forwarded-For，从右向左取第一个不可信的地址，
+// 对端地址未知时按不可信处理
+func clientIP(remote, xff string, trusted []netip.Prefix) (netip.Addr, bool) {
+	peer, peerOK := parseLogAddr(remote)
+	var hops []netip.Addr
+	for _, s := range strings.Split(xff, ",") {
+		if addr, ok := parseLogAddr(s); ok {
+			hops = append(hops, addr)
+		}
+	}
+	if len(hops) == 0 {
+		return peer, peerOK
+	}
+	if len(trusted) == 0 {
+		return hops[0], true
+	}
+	if !peerOK || !isTrusted(peer, trusted) {
+		return peer, peerOK
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !isTrusted(hops[i], trusted) {
+			return hops[i], true
+		}
+	}
+	return hops[0], true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogAddr 解析日志中的地址，允许带端口（1.2.3.4:80、[::1]:80）和 IPv6 zone
+func parseLogAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "-" {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		ap, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = ap.Addr()
+	}
+	return addr.WithZone(""), true
+}
+
+func parseTrustedProxy(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("无效的可信代理: %s", s)
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("无效的可信代理: %s", s)
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// splitLogFields 按空白分割日志行，双引号括起的内容（支持 \ 转义）和方括号括起的时间各为一个字段，
+// 返回的字段不含引号和方括号
+func splitLogFields(line string) []string {
+	var fields []string
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				b.WriteByte(line[j])
+			}
+			fields = append(fields, b.String())
+			i = j + 1
+		case c == '[':
+			j := strings.IndexByte(line[i:], ']')
+			if j < 0 {
+				j = len(line) - i
+			}
+			fields = append(fields, line[i+1:i+j])
+			i += j + 1
+		default:
+			j := strings.IndexAny(line[i:], " \t")
+			if j < 0 {
+				j = len(line) - i
+			}
+			fields = append(fields, line[i:i+j])
+			i += j
+		}
+	}
+	return fields
+}
+
+// jsonString 取出 JSON 字符串的值，不是字符串时返回空
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if len(raw) == 0 || json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+func init() {
+	enrichCmd.PersistentFlags().StringVarP(&enrichLocationFile, "loc", "l", "", "The ip2location .dat file path")
+	enrichCmd.PersistentFlags().StringVarP(&enrichASNFile, "asn", "a", "", "The ip2asn .dat file path")
+	enrichCmd.PersistentFlags().StringVarP(&enrichFormat, "format", "f", "auto", "Log format: clf (common/combined), json or auto (per line)")
+	enrichCmd.PersistentFlags().IntVar(&enrichIPField, "ip-field", 1, "Field number of the client address in log lines")
+	enrichCmd.PersistentFlags().IntVar(&enrichXFFField, "xff-field", 0, "Field number of X-Forwarded-For in log lines (0 to ignore)")
+	enrichCmd.PersistentFlags().StringVar(&enrichIPKey, "ip-key", "remote_addr", "Key of the client address in JSON lines")
+	enrichCmd.PersistentFlags().StringVar(&enrichXFFKey, "xff-key", "http_x_forwarded_for", "Key of X-Forwarded-For in JSON lines")
+	enrichCmd.PersistentFlags().StringVar(&enrichKeyPrefix, "key-prefix", "", "Prefix for the keys added to JSON lines")
+	enrichCmd.PersistentFlags().StringSliceVar(&enrichTrusted, "trusted-proxy", nil, "Trusted proxy IPs or CIDRs, X-Forwarded-For is only honoured from them")
+	rootCmd.AddCommand(enrichCmd)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestSplitLogFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{
+			`1.2.3.4 - - [17/Oct/2026:10:00:00 +0800] "GET / HTTP/1.1" 200 612 "-" "UA \"x\"" "5.6.7.8, 9.9.9.9"`,
+			[]string{"1.2.3.4", "-", "-", "17/Oct/2026:10:00:00 +0800", "GET / HTTP/1.1", "200", "612", "-", `UA "x"`, "5.6.7.8, 9.9.9.9"},
+		},
+		{"a\tb  c", []string{"a", "b", "c"}},
+		{`x "unterminated`, []string{"x", "unterminated"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitLogFields(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLogFields(%q) = %q，期望 %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remote, xff string
+		trusted     []netip.Prefix
+		want        string
+	}{
+		{"1.2.3.4", "", nil, "1.2.3.4"},
+		{"1.2.3.4:8080", "-", nil, "1.2.3.4"},
+		{"[2001:db8::1]:443", "", nil, "2001:db8::1"},
+		{"10.0.0.1", "5.6.7.8, 10.0.0.2", nil, "5.6.7.8"},
+		{"10.0.0.1", "bogus, 5.6.7.8", nil, "5.6.7.8"},
+		{"10.0.0.1", "6.6.6.6, 5.6.7.8, 10.0.0.2", trusted, "5.6.7.8"},
+		{"1.2.3.4", "5.6.7.8", trusted, "1.2.3.4"},
+		{"10.0.0.1", "10.0.0.3, 10.0.0.2", trusted, "10.0.0.3"},
+		// 对端未知时不信任 X-Forwarded-For
+		{"-", "5.6.7.8", trusted, ""},
+		{"bogus", "5.6.7.8", trusted, ""},
+		{"-", "5.6.7.8", nil, "5.6.7.8"},
+		{"-", "", nil, ""},
+	}
+	for _, tt := range tests {
+		got, ok := clientIP(tt.remote, tt.xff, tt.trusted)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("clientIP(%q, %q) = %s，期望无效", tt.remote, tt.xff, got)
+			}
+			continue
+		}
+		if !ok || got != netip.MustParseAddr(tt.want) {
+			t.Errorf("clientIP(%q, %q) = %s, %v，期望 %s", tt.remote, tt.xff, got, ok, tt.want)
+		}
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	e := &enricher{l: newTestLookuper(t, true, true)}
+	tests := []struct {
+		line, want string
+	}{
+		{
+			`1.0.0.1 - - [17/Oct/2026:10:00:00 +0800] "GET / HTTP/1.1" 200 612` + "\n",
+			`1.0.0.1 - - [17/Oct/2026:10:00:00 +0800] "GET / HTTP/1.1" 200 612 "澳大利亚" "-" "-" "13335"` + "\n",
+		},
+		{`8.8.8.8 - -` + "\r\n", `8.8.8.8 - - "-" "-" "-" "-"` + "\r\n"},
+		{
+			`{"remote_addr":"1.0.0.1", "status":200}`,
+			`{"remote_addr":"1.0.0.1", "status":200,"country":"澳大利亚","asn":13335}`,
+		},
+		// 已有的同名键被替换，不产生重复的键
+		{
+			`{"country":"旧","remote_addr":"1.0.0.1","asn":"x","city":"保留"}` + "\n",
+			`{"remote_addr":"1.0.0.1","city":"保留","country":"澳大利亚","asn":13335}` + "\n",
+		},
+		{`{"country":"旧"}`, `{"country":"旧"}`},
+		{`{"remote_addr":"8.8.8.8"}`, `{"remote_addr":"8.8.8.8"}`},
+		{`{}`, `{}`},
+		{"\n", "\n"},
+	}
+	for _, tt := range tests {
+		got, err := e.enrich([]byte(tt.line))
+		if err != nil {
+			t.Fatalf("enrich(%q): %v", tt.line, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("enrich(%q) = %q，期望 %q", tt.line, got, tt.want)
+		}
+		if trimmed := bytes.TrimSpace(got); bytes.HasPrefix(trimmed, []byte("{")) && !json.Valid(trimmed) {
+			t.Errorf("enrich(%q) 输出无效的 JSON", tt.line)
+		}
+	}
+}
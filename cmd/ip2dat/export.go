@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/ip2asn"
+	"github.com/billcoding/ip2dat/ip2loc"
+	"github.com/billcoding/ip2dat/mmdb"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:     "export",
+	Aliases: []string{"x"},
+	Short:   "Export location or ASN data to other database formats such as MMDB.",
+	Long: `Export location or ASN data to other database formats.
+The input is either a TXT or CSV file accepted by the location and asn commands, or a .dat file generated by them.
+--format mmdb writes a MaxMind DB file: locations use the GeoIP2 City layout with zh-CN and en names,
+ASN data uses the GeoLite2 ASN layout. Not-routed ASN ranges (ASN "-") without an organization
+have no MMDB record and are skipped, so lookups in them find nothing; the number skipped is reported.
+The dataset kind of a .dat file is read from the file header; use --kind for TXT or CSV input and legacy files.
+Without --output the file is named after the kind: ip2loc.mmdb or ip2asn.mmdb.`,
+	Example: `ip2dat export -i /to/path/ip2loc.dat -o /to/path/ip2loc.mmdb
+ip2dat export --kind asn -i /to/path/ip2asn.csv -o /to/path/ip2asn.mmdb --format mmdb`,
+	Args: cobra.NoArgs,
+
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, _ []string) error {
+		if exportFormat != "mmdb" {
+			return fmt.Errorf("不支持的导出格式: %s", exportFormat)
+		}
+		if exportKindName == "" && !strings.HasSuffix(strings.ToLower(exportInputFile), ".dat") {
+			return fmt.Errorf("%s: 请用 --kind 指定 location 或 asn", exportInputFile)
+		}
+		kind, err := datasetKind(exportKindName, exportInputFile)
+		if err != nil {
+			return err
+		}
+		columns, err := columnMapping(exportColumns, exportSchema)
+		if err != nil {
+			return fmt.Errorf("列映射错误: %w", err)
+		}
+		var opts []mmdb.WriterOption
+		if exportRecordSize != 0 {
+			opts = append(opts, mmdb.WithRecordSize(exportRecordSize))
+		}
+		if exportDatabaseType != "" {
+			opts = append(opts, mmdb.WithDatabaseType(exportDatabaseType))
+		}
+		output := exportOutputFile
+		if output == "" {
+			output = defaultOutput(kind, ".mmdb")
+		}
+		switch kind {
+		case datfile.KindLocation:
+			return ip2loc.ExportMMDB(exportInputFile, output, columns, opts...)
+		case datfile.KindASN:
+			return ip2asn.ExportMMDB(exportInputFile, output, columns, opts...)
+		}
+		return fmt.Errorf("%s: 无法识别数据集类型，请用 --kind 指定 location 或 asn", exportInputFile)
+	},
+}
+
+var (
+	exportInputFile    string
+	exportOutputFile   string
+	exportFormat       string
+	exportKindName     string
+	exportColumns      string
+	exportSchema       string
+	exportRecordSize   int
+	exportDatabaseType string
+)
+
+func init() {
+	exportCmd.PersistentFlags().StringVarP(&exportInputFile, "input", "i", "ip2loc.dat", "The TXT, CSV or .dat input file path")
+	exportCmd.PersistentFlags().StringVarP(&exportOutputFile, "output", "o", "", "The output file path (default ip2loc.mmdb or ip2asn.mmdb by kind)")
+	exportCmd.PersistentFlags().StringVar(&exportFormat, "format", "mmdb", "Output format: mmdb")
+	exportCmd.PersistentFlags().StringVar(&exportKindName, "kind", "", "Dataset kind for TXT, CSV and legacy .dat input: location or asn")
+	exportCmd.PersistentFlags().StringVar(&exportColumns, "columns", "", "Column mapping for TXT or CSV input, see the location and asn commands")
+	exportCmd.PersistentFlags().StringVar(&exportSchema, "schema", "", "Column mapping file with one name=column per line, overridden by --columns")
+	exportCmd.PersistentFlags().IntVar(&exportRecordSize, "record-size", 0, "MMDB search tree record size in bits: 24, 28 or 32 (0 picks the smallest that fits)")
+	exportCmd.PersistentFlags().StringVar(&exportDatabaseType, "database-type", "", "MMDB database_type metadata, defaults to ip2dat-City or ip2dat-ASN")
+	rootCmd.AddCommand(exportCmd)
+}
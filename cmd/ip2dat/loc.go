@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/billcoding/ip2dat/datfile"
 	"github.com/billcoding/ip2dat/ip2loc"
 	"github.com/billcoding/ip2dat/iplocsearch"
 	"github.com/spf13/cobra"
@@ -11,21 +13,54 @@ import (
 var locationCmd = &cobra.Command{
 	Use:     "location",
 	Aliases: []string{"l", "loc"},
-	Short:   "Convertor IP location from TXT or CSV to .dat.",
-	Long:    `Convertor IP location from TXT or CSV to .dat.`,
-	Example: `ip2dat loc -i /to/path/ip2location.txt -o /to/path/ip2location.dat`,
+	Short:   "Convertor IP location from TXT, CSV, GeoLite2 CSV or MMDB to .dat.",
+	Long:    `Convertor IP location from TXT, CSV, GeoLite2 CSV or MMDB to .dat.`,
+	Example: `ip2dat loc -i /to/path/ip2location.txt -o /to/path/ip2location.dat
+ip2dat loc -i GeoLite2-City-Blocks-IPv4.csv,GeoLite2-City-Blocks-IPv6.csv --geolite2-locations GeoLite2-City-Locations-zh-CN.csv -o /to/path/ip2location.dat
+ip2dat loc -i GeoLite2-City.mmdb --columns country=country.names.zh-CN,city=city.names.zh-CN,cc=country.iso_code -o /to/path/ip2location.dat`,
 	Run: func(_ *cobra.Command, _ []string) {
-		_ = ip2loc.Convert(locationInputFile, locationOutputFile)
-		if locationTest && locationTestIp != "" {
-			fmt.Println(locationTestIp + " location: " + iplocsearch.Search(locationOutputFile, locationTestIp))
+		opts := []datfile.BuilderOption{datfile.WithMaxMemory(locationMaxMemory << 20)}
+		if locationCompress {
+			opts = append(opts, datfile.WithCompression())
+		}
+		if locationGeoLite2 != "" {
+			if ip2loc.ConvertGeoLite2(strings.Split(locationInputFile, ","), locationGeoLite2, locationOutputFile, opts...) == nil {
+				locationTestOutput()
+			}
+			return
+		}
+		columns, err := columnMapping(locationColumns, locationSchema)
+		if err != nil {
+			fmt.Println("列映射错误:", err)
+			return
+		}
+		if isMMDB(locationInputFile) {
+			if ip2loc.ConvertMMDB(locationInputFile, locationOutputFile, columns, opts...) == nil {
+				locationTestOutput()
+			}
+			return
+		}
+		if ip2loc.ConvertColumns(locationInputFile, locationOutputFile, columns, opts...) == nil {
+			locationTestOutput()
 		}
 	}}
 
+func locationTestOutput() {
+	if locationTest && locationTestIp != "" {
+		fmt.Println(locationTestIp + " location: " + iplocsearch.Search(locationOutputFile, locationTestIp))
+	}
+}
+
 var (
 	locationInputFile  string
 	locationOutputFile string
 	locationTest       bool
 	locationTestIp     string
+	locationMaxMemory  int64
+	locationCompress   bool
+	locationColumns    string
+	locationSchema     string
+	locationGeoLite2   string
 )
 
 func init() {
@@ -33,5 +68,10 @@ func init() {
 	locationCmd.PersistentFlags().StringVarP(&locationOutputFile, "output", "o", "ip2loc.dat", "The ip2location output file path")
 	locationCmd.PersistentFlags().BoolVarP(&locationTest, "test", "t", false, "Test after converted")
 	locationCmd.PersistentFlags().StringVar(&locationTestIp, "test-ip", "1.1.1.1", "Test ip address")
+	locationCmd.PersistentFlags().Int64Var(&locationMaxMemory, "max-memory", 0, "Memory budget in MB, spill sorted runs to temp files when exceeded (0 for unlimited)")
+	locationCmd.PersistentFlags().BoolVar(&locationCompress, "compress", false, "Compress the content area into independently compressed blocks")
+	locationCmd.PersistentFlags().StringVar(&locationColumns, "columns", "", "Column mapping such as start=1,end=ip_to,country=country_name: 1-based numbers or header names (columns: start, end, continent, country, province, city, district, isp, areacode, country_en, cc, lon, lat); for .mmdb input, dot separated map paths such as cc=country.iso_code")
+	locationCmd.PersistentFlags().StringVar(&locationSchema, "schema", "", "Column mapping file with one name=column per line, overridden by --columns")
+	locationCmd.PersistentFlags().StringVar(&locationGeoLite2, "geolite2-locations", "", "GeoLite2 Locations CSV file, joins with the comma separated GeoLite2 Blocks CSV files given by --input")
 	rootCmd.AddCommand(locationCmd)
 }
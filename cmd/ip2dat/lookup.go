@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/billcoding/ip2dat/ipasnsearch"
+	"github.com/billcoding/ip2dat/iplocsearch"
+	"github.com/spf13/cobra"
+)
+
+var lookupCmd = &cobra.Command{
+	Use:     "lookup [IP...]",
+	Aliases: []string{"q", "query"},
+	Short:   "Look up IPs in existing .dat files.",
+	Long: `Look up IPs in existing location and/or ASN .dat files without converting.
+IPs are taken from the arguments, or read from stdin one per line when no argument is given.
+Output formats: plain, tsv (ip, location, asn) or json (one object per line).`,
+	Example: `ip2dat lookup --loc /to/path/ip2loc.dat 1.1.1.1 2001:db8::1
+cat ips.txt | ip2dat lookup --loc ip2loc.dat --asn ip2asn.dat --format json`,
+
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		if lookupLocationFile == "" && lookupASNFile == "" {
+			return fmt.Errorf("至少需要指定 --loc 或 --asn")
+		}
+		write, ok := lookupWriters[lookupFormat]
+		if !ok {
+			return fmt.Errorf("不支持的输出格式: %s", lookupFormat)
+		}
+		l, err := openLookuper(lookupLocationFile, lookupASNFile)
+		if err != nil {
+			return err
+		}
+		defer l.close()
+		return l.run(args, os.Stdin, os.Stdout, write)
+	},
+}
+
+var (
+	lookupLocationFile string
+	lookupASNFile      string
+	lookupFormat       string
+)
+
+// lookupWriter 输出单个 IP 的查询结果，datasets 为指定了文件的数据集名称
+type lookupWriter func(w io.Writer, datasets []string, r lookupResult) error
+
+// lookupWriters 各输出格式
+var lookupWriters = map[string]lookupWriter{
+	"plain": writeLookupPlain,
+	"tsv":   writeLookupTSV,
+	"json":  writeLookupJSON,
+}
+
+// lookuper 同时查询地理位置和 ASN，未指定的数据集为 nil
+type lookuper struct {
+	loc *iplocsearch.Searcher
+	asn *ipasnsearch.Searcher
+}
+
+// lookupResult 单个 IP 的查询结果，未命中或未指定的数据集为 nil
+type lookupResult struct {
+	IP       string          `json:"ip"`
+	Location *lookupLocation `json:"location,omitempty"`
+	ASN      *lookupASN      `json:"asn,omitempty"`
+	Error    string          `json:"error,omitempty"`
+
+	texts map[string]string // 文件中存储的原始内容，plain 和 tsv 原样输出
+}
+
+type lookupLocation struct {
+	Continent   string  `json:"continent"`
+	Country     string  `json:"country"`
+	Province    string  `json:"province"`
+	City        string  `json:"city"`
+	District    string  `json:"district"`
+	ISP         string  `json:"isp"`
+	AreaCode    string  `json:"area_code"`
+	CountryEN   string  `json:"country_en"`
+	CountryCode string  `json:"country_code"`
+	Longitude   float64 `json:"longitude"`
+	Latitude    float64 `json:"latitude"`
+}
+
+type lookupASN struct {
+	Prefix       string `json:"prefix,omitempty"`
+	ASN          uint32 `json:"asn"`
+	Organization string `json:"organization"`
+	Routed       bool   `json:"routed"`
+}
+
+// run 查询 args 中的 IP，args 为空时从 in 逐行读取，结果按 write 的格式写到 w。
+// 有 IP 查询失败时全部输出后返回错误
+func (l *lookuper) run(args []string, in io.Reader, w io.Writer, write lookupWriter) error {
+	out := bufio.NewWriter(w)
+	datasets := l.datasets()
+	failed := 0
+	each := func(ip string) error {
+		r, err := l.lookup(ip)
+		if err != nil {
+			return err
+		}
+		if r.Error != "" {
+			failed++
+		}
+		return write(out, datasets, r)
+	}
+	var err error
+	if len(args) > 0 {
+		for _, ip := range args {
+			if err = each(ip); err != nil {
+				break
+			}
+		}
+	} else {
+		err = eachLine(in, each)
+	}
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d 个 IP 查询失败", failed)
+	}
+	return nil
+}
+
+// datasets 返回指定了文件的数据集名称，决定 plain 和 tsv 输出的列
+func (l *lookuper) datasets() []string {
+	var names []string
+	if l.loc != nil {
+		names = append(names, "location")
+	}
+	if l.asn != nil {
+		names = append(names, "asn")
+	}
+	return names
+}
+
+// lookup 查询单个 IP。IP 无效或内容无法解析时记录在结果的 Error 中，
+// 只有读取文件出错或文件损坏时返回错误
+func (l *lookuper) lookup(ip string) (lookupResult, error) {
+	r := lookupResult{IP: ip, texts: make(map[string]string)}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		r.Error = fmt.Sprintf("%v: %q", iplocsearch.ErrInvalidIP, ip)
+		return r, nil
+	}
+	if l.loc != nil {
+		text, err := l.loc.FindAddr(addr)
+		switch {
+		case err == nil:
+			r.texts["location"] = text
+			r.Location = newLookupLocation(iplocsearch.ParseLocation(text))
+		case !errors.Is(err, iplocsearch.ErrNotFound):
+			return r, err
+		}
+	}
+	if l.asn != nil {
+		text, err := l.asn.FindAddr(addr)
+		switch {
+		case err == nil:
+			r.texts["asn"] = text
+			rec, err := ipasnsearch.ParseASNRecord(text)
+			if err != nil {
+				r.Error = fmt.Sprintf("asn: %v", err)
+				break
+			}
+			r.ASN = newLookupASN(rec)
+		case !errors.Is(err, ipasnsearch.ErrNotFound):
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func newLookupLocation(loc iplocsearch.Location) *lookupLocation {
+	return &lookupLocation{
+		Continent:   loc.Continent,
+		Country:     loc.Country,
+		Province:    loc.Province,
+		City:        loc.City,
+		District:    loc.District,
+		ISP:         loc.ISP,
+		AreaCode:    loc.AreaCode,
+		CountryEN:   loc.CountryEN,
+		CountryCode: loc.CountryCode,
+		Longitude:   loc.Longitude,
+		Latitude:    loc.Latitude,
+	}
+}
+
+func newLookupASN(rec ipasnsearch.ASNRecord) *lookupASN {
+	a := &lookupASN{ASN: rec.ASN, Organization: rec.Organization, Routed: rec.Routed}
+	if rec.Prefix.IsValid() {
+		a.Prefix = rec.Prefix.String()
+	}
+	return a
+}
+
+// openLookuper 打开地理位置和 ASN 文件，文件名为空的数据集不打开
+func openLookuper(locFile, asnFile string) (*lookuper, error) {
+	l := &lookuper{}
+	if locFile != "" {
+		s, err := iplocsearch.New(locFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", locFile, err)
+		}
+		l.loc = s
+	}
+	if asnFile != "" {
+		s, err := ipasnsearch.New(asnFile)
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("%s: %w", asnFile, err)
+		}
+		l.asn = s
+	}
+	return l, nil
+}
+
+func (l *lookuper) close() {
+	if l.loc != nil {
+		_ = l.loc.Close()
+	}
+	if l.asn != nil {
+		_ = l.asn.Close()
+	}
+}
+
+// eachLine 对 r 中每个非空行调用 fn，忽略首尾空白和 # 开头的注释行
+func eachLine(r io.Reader, fn func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取输入失败: %w", err)
+	}
+	return nil
+}
+
+func writeLookupPlain(w io.Writer, datasets []string, r lookupResult) error {
+	if r.Error != "" {
+		if _, err := fmt.Fprintf(w, "%s error: %s\n", r.IP, r.Error); err != nil {
+			return err
+		}
+	}
+	for _, name := range datasets {
+		text, ok := r.texts[name]
+		if !ok {
+			if r.Error != "" {
+				continue
+			}
+			text = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s: %s\n", r.IP, name, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLookupTSV 每个 IP 输出一行，错误写到标准错误，保持列数不变
+func writeLookupTSV(w io.Writer, datasets []string, r lookupResult) error {
+	if r.Error != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "%s: %s\n", r.IP, r.Error)
+	}
+	fields := []string{r.IP}
+	for _, name := range datasets {
+		fields = append(fields, r.texts[name])
+	}
+	_, err := fmt.Fprintln(w, strings.Join(fields, "\t"))
+	return err
+}
+
+func writeLookupJSON(w io.Writer, _ []string, r lookupResult) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+func init() {
+	lookupCmd.PersistentFlags().StringVarP(&lookupLocationFile, "loc", "l", "", "The ip2location .dat file path")
+	lookupCmd.PersistentFlags().StringVarP(&lookupASNFile, "asn", "a", "", "The ip2asn .dat file path")
+	lookupCmd.PersistentFlags().StringVarP(&lookupFormat, "format", "f", "plain", "Output format: plain, tsv or json")
+	rootCmd.AddCommand(lookupCmd)
+}
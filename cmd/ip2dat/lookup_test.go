@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// writeTestDat 生成只有给定网段的 .dat 文件
+func writeTestDat(t *testing.T, kind datfile.Kind, ranges map[string]string) string {
+	t.Helper()
+	b := datfile.NewBuilder(kind)
+	defer b.Close()
+	for prefix, text := range ranges {
+		if err := b.AddPrefix(netip.MustParsePrefix(prefix), text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "test.dat")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func newTestLookuper(t *testing.T, loc, asn bool) *lookuper {
+	t.Helper()
+	var locFile, asnFile string
+	if loc {
+		locFile = writeTestDat(t, datfile.KindLocation, map[string]string{
+			"1.0.0.0/24":    "大洋洲|澳大利亚|||||||AU|153.025|-27.47",
+			"2001:db8::/32": "|文档",
+		})
+	}
+	if asn {
+		asnFile = writeTestDat(t, datfile.KindASN, map[string]string{
+			"1.0.0.0/24": "1.0.0.0/24|13335|CloudFlare Inc.",
+			"1.0.1.0/24": "1.0.1.0/24|bad|x",
+			"0.0.0.0/8":  "0.0.0.0/8|-|-",
+		})
+	}
+	l, err := openLookuper(locFile, asnFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(l.close)
+	return l
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name     string
+		loc, asn bool
+		format   string
+		args     []string
+		stdin    string
+		want     string
+		failed   string // 期望的错误，为空时应成功
+	}{
+		{
+			name: "plain", loc: true, asn: true, format: "plain",
+			args: []string{"1.0.0.1", "8.8.8.8"},
+			want: "1.0.0.1 location: 大洋洲|澳大利亚|||||||AU|153.025|-27.47\n" +
+				"1.0.0.1 asn: 1.0.0.0/24|13335|CloudFlare Inc.\n" +
+				"8.8.8.8 location: -\n" +
+				"8.8.8.8 asn: -\n",
+		},
+		{
+			name: "plain stdin", loc: true, format: "plain",
+			stdin: "# 注释\n\n  2001:db8::1  \n1.0.0.255\n",
+			want:  "2001:db8::1 location: |文档\n1.0.0.255 location: 大洋洲|澳大利亚|||||||AU|153.025|-27.47\n",
+		},
+		{
+			name: "args override stdin", asn: true, format: "plain",
+			args: []string{"0.1.2.3"}, stdin: "1.0.0.1\n",
+			want: "0.1.2.3 asn: 0.0.0.0/8|-|-\n",
+		},
+		{
+			name: "plain invalid", loc: true, asn: true, format: "plain",
+			args: []string{"1.2.3", "1.0.1.1", "1.0.0.1"},
+			want: "1.2.3 error: 无效的 IP: \"1.2.3\"\n" +
+				"1.0.1.1 error: asn: 无效的 ASN: bad\n" +
+				"1.0.1.1 asn: 1.0.1.0/24|bad|x\n" +
+				"1.0.0.1 location: 大洋洲|澳大利亚|||||||AU|153.025|-27.47\n" +
+				"1.0.0.1 asn: 1.0.0.0/24|13335|CloudFlare Inc.\n",
+			failed: "2 个 IP 查询失败",
+		},
+		{
+			name: "tsv", loc: true, asn: true, format: "tsv",
+			stdin: "1.0.0.1\n8.8.8.8\nabc\n",
+			want: "1.0.0.1\t大洋洲|澳大利亚|||||||AU|153.025|-27.47\t1.0.0.0/24|13335|CloudFlare Inc.\n" +
+				"8.8.8.8\t\t\n" +
+				"abc\t\t\n",
+			failed: "1 个 IP 查询失败",
+		},
+		{
+			name: "json", loc: true, asn: true, format: "json",
+			args: []string{"1.0.0.1", "0.1.2.3", " 8.8.8.8"},
+			want: `{"ip":"1.0.0.1","location":{"continent":"大洋洲","country":"澳大利亚","province":"","city":"","district":"","isp":"","area_code":"","country_en":"","country_code":"AU","longitude":153.025,"latitude":-27.47},"asn":{"prefix":"1.0.0.0/24","asn":13335,"organization":"CloudFlare Inc.","routed":true}}` + "\n" +
+				`{"ip":"0.1.2.3","asn":{"prefix":"0.0.0.0/8","asn":0,"organization":"","routed":false}}` + "\n" +
+				`{"ip":" 8.8.8.8","error":"无效的 IP: \" 8.8.8.8\""}` + "\n",
+			failed: "1 个 IP 查询失败",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLookuper(t, tt.loc, tt.asn)
+			var out bytes.Buffer
+			err := l.run(tt.args, strings.NewReader(tt.stdin), &out, lookupWriters[tt.format])
+			if got := out.String(); got != tt.want {
+				t.Errorf("输出:\n%s\n期望:\n%s", got, tt.want)
+			}
+			switch {
+			case tt.failed == "" && err != nil:
+				t.Errorf("run: %v", err)
+			case tt.failed != "" && (err == nil || err.Error() != tt.failed):
+				t.Errorf("run = %v，期望 %s", err, tt.failed)
+			}
+			if tt.format == "json" {
+				for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+					var r lookupResult
+					if err := json.Unmarshal([]byte(line), &r); err != nil {
+						t.Errorf("无效的 JSON %s: %v", line, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOpenLookuper(t *testing.T) {
+	locFile := writeTestDat(t, datfile.KindLocation, map[string]string{"1.0.0.0/24": "亚洲"})
+	if _, err := openLookuper(locFile, locFile); err == nil {
+		t.Error("ASN 使用地理位置文件时应返回错误")
+	}
+	if _, err := openLookuper(filepath.Join(t.TempDir(), "missing.dat"), ""); err == nil {
+		t.Error("文件不存在时应返回错误")
+	}
+}
@@ -11,10 +11,13 @@ var rootCmd = &cobra.Command{
 	Use:   "ip2dat",
 	Short: "The IP .dat converter written in Go.",
 	Long:  "The IP .dat converter written in Go.",
+
+	SilenceErrors: true,
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// TestCommandNames 子命令的名称和别名不能重复，否则后注册的命令无法通过别名调用
+func TestCommandNames(t *testing.T) {
+	seen := make(map[string]string)
+	for _, cmd := range rootCmd.Commands() {
+		for _, name := range append([]string{cmd.Name()}, cmd.Aliases...) {
+			if other, ok := seen[name]; ok {
+				t.Errorf("%s 同时是 %s 和 %s 的名称或别名", name, other, cmd.Name())
+			}
+			seen[name] = cmd.Name()
+		}
+	}
+}
+
+func TestDefaultOutput(t *testing.T) {
+	tests := []struct {
+		kind datfile.Kind
+		ext  string
+		want string
+	}{
+		{datfile.KindLocation, ".txt", "ip2loc.txt"},
+		{datfile.KindASN, ".txt", "ip2asn.txt"},
+		{datfile.KindASN, ".mmdb", "ip2asn.mmdb"},
+		{datfile.KindCustom, ".txt", ""},
+		{datfile.KindUnknown, ".txt", ""},
+	}
+	for _, tt := range tests {
+		if got := defaultOutput(tt.kind, tt.ext); got != tt.want {
+			t.Errorf("defaultOutput(%v, %q) = %q，期望 %q", tt.kind, tt.ext, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:     "verify FILE...",
+	Aliases: []string{"v"},
+	Short:   "Verify checksum and structure of .dat files.",
+	Long:    `Verify checksum and structure of .dat files: offsets, prefix table, record order and payload pointers.`,
+	Example: `ip2dat verify /to/path/ip2loc.dat /to/path/ip2asn.dat`,
+	Args:    cobra.MinimumNArgs(1),
+
+	SilenceUsage: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		failed := 0
+		for _, file := range args {
+			problems := verifyFile(file)
+			if len(problems) == 0 {
+				fmt.Printf("%s: OK\n", file)
+				continue
+			}
+			failed++
+			fmt.Printf("%s: %d 个问题\n", file, len(problems))
+			for _, p := range problems {
+				fmt.Printf("  - %v\n", p)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d 个文件校验失败", failed)
+		}
+		return nil
+	},
+}
+
+func verifyFile(file string) []error {
+	r, err := datfile.Open(file)
+	if err != nil {
+		return []error{err}
+	}
+	return r.Verify()
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
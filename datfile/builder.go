@@ -0,0 +1,365 @@
+package datfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"time"
+)
+
+// record 一条 IP 范围和对应内容的索引，起止 IP 统一为 16 字节形式，
+// IPv4 以 IPv4 映射地址存储，保证 IPv4 与 IPv6 混排时顺序一致
+type record struct {
+	start, end [16]byte
+	payload    uint32
+}
+
+const (
+	// recordMemSize 单条记录在内存和临时文件中占用的字节数
+	recordMemSize = 36
+	// minRunRecords 每个临时文件至少包含的记录数，避免内容占满内存限制后每条记录都写一次文件
+	minRunRecords = 1 << 14
+	// maxRuns 临时文件数达到该值时先归并成一个，限制同时打开的文件数
+	maxRuns = 64
+)
+
+// BuilderOption NewBuilder 的可选配置
+type BuilderOption func(*Builder)
+
+// WithMaxMemory 限制 Builder 占用的内存（字节）。内容改为存放在临时文件中，
+// 记录超过限制时排序后写入临时文件，生成时再多路归并。0 表示不限制
+func WithMaxMemory(n int64) BuilderOption {
+	return func(b *Builder) {
+		b.maxMemory = n
+	}
+}
+
+// WithTempDir 指定临时文件目录，默认为 os.TempDir()
+func WithTempDir(dir string) BuilderOption {
+	return func(b *Builder) {
+		b.tempDir = dir
+	}
+}
+
+// WithColumnDict 内容按 | 分列，每列存为列字典中的序号，适合各列取值重复较多的数据集。
+// 列字典始终保存在内存中
+func WithColumnDict() BuilderOption {
+	return func(b *Builder) {
+		b.dict = &columnDict{}
+	}
+}
+
+// WithCompression 内容切分成小块分别压缩，查询时按需解压，文件更小但查询更慢
+func WithCompression() BuilderOption {
+	return func(b *Builder) {
+		b.compress = true
+	}
+}
+
+// Builder 收集 IP 范围和内容并生成 .dat 文件，相同的内容只存储一次。
+// 设置 WithMaxMemory 后 Builder 会创建临时文件，使用完毕需调用 Close
+type Builder struct {
+	kind      Kind
+	ipv6      bool
+	maxMemory int64
+	tempDir   string
+	records   []record   // 内存中尚未写入临时文件的记录
+	runs      []*os.File // 已排序写入临时文件的记录段
+	count     int        // 记录总数
+	payloads  *payloadStore
+	dict      *columnDict // 为 nil 时不使用列字典
+	compress  bool
+}
+
+// NewBuilder 创建指定数据集类型的 Builder
+func NewBuilder(kind Kind, opts ...BuilderOption) *Builder {
+	b := &Builder{kind: kind}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.payloads = newPayloadStore(b.maxMemory > 0, b.tempDir)
+	return b
+}
+
+// Add 添加一条 IP 范围，IPv4 映射的 IPv6 地址按 IPv4 处理，起止 IP 版本必须一致。
+// 只要有一条 IPv6 记录就生成 IPv6 格式
+func (b *Builder) Add(start, end netip.Addr, payload string) error {
+	if err := CheckRange(start, end); err != nil {
+		return err
+	}
+	if uint64(len(payload)) > uint64(^uint32(0)) {
+		return fmt.Errorf("内容长度超出上限: %d 字节", len(payload))
+	}
+	start, end = start.Unmap().WithZone(""), end.Unmap().WithZone("")
+	if start.Is6() {
+		b.ipv6 = true
+	}
+
+	var dictMemory int64
+	if b.dict != nil {
+		payload = b.dict.encode(payload)
+		dictMemory = b.dict.memory
+	}
+	idx, err := b.payloads.add(payload)
+	if err != nil {
+		return err
+	}
+	b.records = append(b.records, record{start: start.As16(), end: end.As16(), payload: idx})
+	b.count++
+	if b.maxMemory > 0 && len(b.records) >= minRunRecords &&
+		int64(len(b.records))*recordMemSize+b.payloads.memory+dictMemory > b.maxMemory {
+		return b.spill()
+	}
+	return nil
+}
+
+// CheckRange 检查起止 IP 是否合法、版本一致且顺序正确，IPv4 映射的 IPv6 地址按 IPv4 处理。
+// Add 只会因为 CheckRange 失败、内容超过 4GB 或临时文件读写失败返回错误
+func CheckRange(start, end netip.Addr) error {
+	if !start.IsValid() || !end.IsValid() {
+		return ErrInvalidIP
+	}
+	start, end = start.Unmap(), end.Unmap()
+	if start.Is4() != end.Is4() {
+		return fmt.Errorf("起止 IP 版本不一致: %s - %s", start, end)
+	}
+	if end.WithZone("").Less(start.WithZone("")) {
+		return fmt.Errorf("结束 IP 小于起始 IP: %s - %s", start, end)
+	}
+	return nil
+}
+
+// AddPrefix 添加一个网段，等同于 Add(网段首地址, 网段末地址, payload)
+func (b *Builder) AddPrefix(prefix netip.Prefix, payload string) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("无效的网段: %s", prefix)
+	}
+	prefix = prefix.Masked()
+	return b.Add(prefix.Addr(), LastAddr(prefix), payload)
+}
+
+// LastAddr 返回网段的最后一个地址
+func LastAddr(prefix netip.Prefix) netip.Addr {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4() {
+		a := prefix.Addr().As4()
+		n := binary.BigEndian.Uint32(a[:]) | (uint32(1)<<(32-prefix.Bits()) - 1)
+		binary.BigEndian.PutUint32(a[:], n)
+		return netip.AddrFrom4(a)
+	}
+	a := prefix.Addr().As16()
+	for i := prefix.Bits(); i < 128; i++ {
+		a[i/8] |= 0x80 >> (i % 8)
+	}
+	return netip.AddrFrom16(a)
+}
+
+// Len 返回已添加的记录数
+func (b *Builder) Len() int {
+	return b.count
+}
+
+// Close 删除 Builder 创建的临时文件
+func (b *Builder) Close() error {
+	for _, f := range b.runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	b.runs = nil
+	return b.payloads.close()
+}
+
+// Bytes 按起始 IP 排序记录并生成完整的文件内容
+func (b *Builder) Bytes() ([]byte, error) {
+	var buffer bytes.Buffer
+	if _, err := b.WriteTo(&buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// WriteTo 按起始 IP 排序记录并把文件内容依次写入 w，实现 io.WriterTo。
+// 内存中的记录和临时文件中的记录段归并两遍：第一遍生成前缀区，第二遍写出索引区
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	sortRecords(b.records)
+	if b.count > int(^uint32(0)) {
+		return 0, fmt.Errorf("记录数超出上限: %d", b.count)
+	}
+
+	// 前缀区：256 * 9字节
+	prefixStartOffset := uint32(headerSize)
+	prefixEndOffset := prefixStartOffset + prefixCount*prefixSize - 1
+	firstStartIpOffset := prefixEndOffset + 1
+	var starts, ends [prefixCount]uint32
+	var seen [prefixCount]bool
+	i := uint32(0)
+	err := b.merge(func(r record) error {
+		// 跨越多个前缀的记录登记在它覆盖的每个前缀中
+		for prefix := b.prefixOf(r.start); prefix <= b.prefixOf(r.end); prefix++ {
+			if !seen[prefix] {
+				seen[prefix] = true
+				starts[prefix] = i
+			}
+			ends[prefix] = i
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for prefix := range seen {
+		if !seen[prefix] {
+			starts[prefix], ends[prefix] = emptyBucket, emptyBucket
+		}
+	}
+
+	// 内容区紧跟索引区，使用列字典时内容区以列字典开头
+	dataOffset := uint64(firstStartIpOffset) + uint64(b.count)*uint64(recordSize(Version, b.ipv6))
+	var dictOffset uint32
+	if b.dict != nil {
+		dictOffset = uint32(dataOffset)
+		dataOffset += b.dict.size()
+	}
+	var offsets []uint32
+	var blocks *blockStore
+	var blockTableOffset uint32
+	if b.compress {
+		// 压缩时内容偏移为块序号和块内偏移，块表和压缩块紧跟列字典
+		blockTableOffset = uint32(dataOffset)
+		blocks, offsets, err = b.compressPayloads()
+		defer blocks.close()
+		if err != nil {
+			return 0, err
+		}
+		dataOffset += blocks.tableSize() + blocks.size
+	} else {
+		offsets = make([]uint32, b.payloads.len())
+		lengthBytes := make([]byte, binary.MaxVarintLen64)
+		for i := range offsets {
+			offsets[i] = uint32(dataOffset)
+			length := uint64(b.payloads.length(uint32(i)))
+			dataOffset += uint64(binary.PutUvarint(lengthBytes, length)) + length
+		}
+	}
+	if dataOffset > uint64(^uint32(0)) {
+		return 0, fmt.Errorf("文件大小超出 4GB 上限: %d 字节", dataOffset)
+	}
+
+	crc := crc32.New(crc32cTable)
+	bw := bufio.NewWriterSize(io.MultiWriter(w, crc), 1<<16)
+	cw := &countingWriter{w: bw}
+
+	header := make([]byte, headerSize)
+	putHeader(header, Header{
+		Version:      Version,
+		Kind:         b.kind,
+		IPv6:         b.ipv6,
+		Checksum:     true,
+		ColumnDict:   b.dict != nil,
+		Compressed:   b.compress,
+		RecordCount:  uint32(b.count),
+		PayloadCount: uint32(b.payloads.len()),
+		BuildTime:    time.Now(),
+	}, firstStartIpOffset, prefixStartOffset, prefixEndOffset, dictOffset, blockTableOffset)
+	_, _ = cw.Write(header)
+
+	entry := make([]byte, prefixSize)
+	for prefix := 0; prefix < prefixCount; prefix++ {
+		entry[0] = byte(prefix)
+		binary.LittleEndian.PutUint32(entry[1:5], starts[prefix])
+		binary.LittleEndian.PutUint32(entry[5:9], ends[prefix])
+		_, _ = cw.Write(entry)
+	}
+
+	// 索引区：IPv4 12字节每条，IPv6 36字节每条（4字节偏移）
+	offsetBytes := make([]byte, 4)
+	err = b.merge(func(r record) error {
+		_, _ = cw.Write(b.ipBytes(r.start))
+		_, _ = cw.Write(b.ipBytes(r.end))
+		binary.LittleEndian.PutUint32(offsetBytes, offsets[r.payload])
+		_, err := cw.Write(offsetBytes)
+		return err
+	})
+	if err != nil {
+		return cw.n, err
+	}
+
+	if b.dict != nil {
+		if err = b.dict.writeTo(cw); err != nil {
+			return cw.n, err
+		}
+	}
+	if blocks != nil {
+		err = blocks.writeTo(cw, blockTableOffset)
+	} else {
+		err = b.payloads.writeTo(cw)
+	}
+	if err != nil {
+		return cw.n, err
+	}
+	if err = bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	checksum := make([]byte, 4)
+	binary.LittleEndian.PutUint32(checksum, crc.Sum32())
+	n, err := w.Write(checksum)
+	return cw.n + int64(n), err
+}
+
+// prefixOf 返回 16 字节形式的 IP 在输出格式下所在的前缀
+func (b *Builder) prefixOf(ip [16]byte) uint32 {
+	if b.ipv6 {
+		return uint32(ip[0])
+	}
+	return uint32(ip[12])
+}
+
+// ipBytes IPv4 格式写 4 字节小端整数，IPv6 格式写 16 字节网络字节序
+func (b *Builder) ipBytes(ip [16]byte) []byte {
+	if b.ipv6 {
+		return ip[:]
+	}
+	out := make([]byte, 4)
+	binary.LittleEndian.PutUint32(out, binary.BigEndian.Uint32(ip[12:16]))
+	return out
+}
+
+// prefixOf 返回 IP 所在的前缀，IPv6 格式下 IP 必须为 16 字节形式
+func prefixOf(ip netip.Addr) uint32 {
+	if ip.Is4() {
+		return uint32(ip.As4()[0])
+	}
+	return uint32(ip.As16()[0])
+}
+
+func sortRecords(records []record) {
+	sort.Slice(records, func(i, j int) bool {
+		return recordLess(records[i], records[j])
+	})
+}
+
+func recordLess(a, b record) bool {
+	if c := bytes.Compare(a.start[:], b.start[:]); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a.end[:], b.end[:]) < 0
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
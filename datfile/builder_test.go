@@ -0,0 +1,297 @@
+package datfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type testRange struct {
+	start, end string
+	payload    string
+}
+
+// fillerCount 足够让 WithMaxMemory 写出多个临时文件
+const fillerCount = 3*minRunRecords + 100
+
+// testRanges 返回测试用的 IP 范围，范围之间留有间隔，便于检查边界外的查询
+func testRanges(ipv6 bool) []testRange {
+	ranges := []testRange{
+		{"1.0.0.0", "1.0.0.255", "大洋洲|澳大利亚|||||||AU|153.025|-27.470"},
+		{"2.255.0.0", "3.0.255.255", "跨前缀|2-3"},
+		{"4.0.0.1", "4.0.0.1", "单个|IP"},
+		{"5.0.0.0", "5.0.0.9", strings.Repeat("长", 100) + "|" + strings.Repeat("x", 300)},
+		{"6.0.0.0", "6.0.0.0", strings.Repeat("z", 70<<10)},
+		{"255.255.255.0", "255.255.255.255", "最后|一段"},
+	}
+	if ipv6 {
+		ranges = append(ranges,
+			testRange{"2001:db8::", "2001:db8::ffff", "v6|文档"},
+			testRange{"2aff:ff00::", "2b00:ff::", "v6|跨前缀"},
+			testRange{"ffff:ffff::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "v6|最后"},
+		)
+	}
+	return ranges
+}
+
+// fillerRange 第 i 条填充记录：10.0.0.0 起的连续 /24，内容只有少量取值
+func fillerRange(i int) (netip.Addr, netip.Addr, string) {
+	start := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0})
+	end := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 255})
+	return start, end, fmt.Sprintf("填充|%d|%d", i%7, i%100)
+}
+
+func buildTestFile(t *testing.T, ipv6 bool, opts ...BuilderOption) []byte {
+	t.Helper()
+	b := NewBuilder(KindCustom, opts...)
+	defer b.Close()
+	// 倒序添加，验证排序和多路归并
+	for i := fillerCount - 1; i >= 0; i-- {
+		start, end, payload := fillerRange(i)
+		if err := b.Add(start, end, payload); err != nil {
+			t.Fatalf("Add %s: %v", start, err)
+		}
+	}
+	for _, r := range testRanges(ipv6) {
+		if err := b.Add(netip.MustParseAddr(r.start), netip.MustParseAddr(r.end), r.payload); err != nil {
+			t.Fatalf("Add %s-%s: %v", r.start, r.end, err)
+		}
+	}
+	if b.maxMemory > 0 && len(b.runs) < 2 {
+		t.Errorf("只写出 %d 个临时文件", len(b.runs))
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildAndFind(t *testing.T) {
+	options := []struct {
+		name       string
+		opts       func(dir string) []BuilderOption
+		columnDict bool
+		compressed bool
+	}{
+		{"plain", func(string) []BuilderOption { return nil }, false, false},
+		{"dict", func(string) []BuilderOption { return []BuilderOption{WithColumnDict()} }, true, false},
+		{"compress", func(string) []BuilderOption { return []BuilderOption{WithCompression()} }, false, true},
+		{"spill", func(dir string) []BuilderOption {
+			return []BuilderOption{WithMaxMemory(1), WithTempDir(dir)}
+		}, false, false},
+		{"dict-compress-spill", func(dir string) []BuilderOption {
+			return []BuilderOption{WithColumnDict(), WithCompression(), WithMaxMemory(1), WithTempDir(dir)}
+		}, true, true},
+	}
+	for _, opt := range options {
+		for _, ipv6 := range []bool{false, true} {
+			name := opt.name + "/ipv4"
+			if ipv6 {
+				name = opt.name + "/ipv6"
+			}
+			t.Run(name, func(t *testing.T) {
+				dir := t.TempDir()
+				data := buildTestFile(t, ipv6, opt.opts(dir)...)
+				if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+					t.Errorf("临时文件未清理: %d 个", len(entries))
+				}
+
+				r, err := NewReader(data, WithVerify())
+				if err != nil {
+					t.Fatalf("NewReader: %v", err)
+				}
+				h := r.Header()
+				if h.Version != Version || h.IPv6 != ipv6 || h.ColumnDict != opt.columnDict || h.Compressed != opt.compressed {
+					t.Errorf("Header = %+v", h)
+				}
+				if want := uint32(fillerCount + len(testRanges(ipv6))); h.RecordCount != want {
+					t.Errorf("RecordCount = %d，期望 %d", h.RecordCount, want)
+				}
+				if problems := r.Verify(); len(problems) > 0 {
+					t.Fatalf("Verify: %v", problems)
+				}
+				checkRanges(t, r, ipv6)
+			})
+		}
+	}
+}
+
+func checkRanges(t *testing.T, r *Reader, ipv6 bool) {
+	t.Helper()
+	expect := func(addr netip.Addr, want string) {
+		t.Helper()
+		got, err := r.Find(addr)
+		if err != nil {
+			t.Errorf("Find(%s): %v", addr, err)
+			return
+		}
+		if got != want {
+			t.Errorf("Find(%s) = %.40q，期望 %.40q", addr, got, want)
+		}
+	}
+	expectMiss := func(addr netip.Addr) {
+		t.Helper()
+		if got, err := r.Find(addr); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Find(%s) = %.40q, %v，期望 ErrNotFound", addr, got, err)
+		}
+	}
+
+	for _, tr := range testRanges(ipv6) {
+		start, end := netip.MustParseAddr(tr.start), netip.MustParseAddr(tr.end)
+		expect(start, tr.payload)
+		expect(end, tr.payload)
+		if start.Is4() {
+			expect(netip.AddrFrom16(start.As16()), tr.payload)
+			expect(netip.AddrFrom16(end.As16()), tr.payload)
+		}
+		if prev := start.Prev(); prev.IsValid() {
+			expectMiss(prev)
+		}
+		if next := end.Next(); next.IsValid() && !(end.Is4() && next.Is6()) {
+			expectMiss(next)
+		}
+	}
+	for _, i := range []int{0, 1, minRunRecords - 1, minRunRecords, 2 * minRunRecords, fillerCount - 1} {
+		start, end, payload := fillerRange(i)
+		expect(start, payload)
+		expect(end, payload)
+	}
+	expectMiss(netip.MustParseAddr("2.254.255.255"))
+	expectMiss(netip.MustParseAddr("3.1.0.0"))
+	v6 := netip.MustParseAddr("2001:db8::1")
+	if ipv6 {
+		expect(v6, "v6|文档")
+		expect(netip.MustParseAddr("2aff:ffff::1"), "v6|跨前缀")
+		expectMiss(netip.MustParseAddr("2001:db9::"))
+	} else {
+		expectMiss(v6)
+	}
+}
+
+// TestOpenFile 写入文件后分别用 Open、OpenMmap、OpenFS、NewReaderAt 读取，结果与内存中读取一致
+func TestOpenFile(t *testing.T) {
+	data := buildTestFile(t, true, WithColumnDict(), WithCompression())
+	dir := t.TempDir()
+	name := filepath.Join(dir, "test.dat")
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(name, WithVerify())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	checkRanges(t, r, true)
+	if err := r.Close(); err != nil {
+		t.Errorf("Open 的 Reader Close: %v", err)
+	}
+
+	r, err = OpenFS(os.DirFS(dir), "test.dat", WithVerify())
+	if err != nil {
+		t.Fatalf("OpenFS: %v", err)
+	}
+	checkRanges(t, r, true)
+	if _, err := OpenFS(os.DirFS(dir), "missing.dat"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFS 不存在的文件 = %v", err)
+	}
+
+	r, err = OpenMmap(name, WithVerify())
+	if err != nil {
+		t.Fatalf("OpenMmap: %v", err)
+	}
+	if problems := r.Verify(); len(problems) > 0 {
+		t.Fatalf("Verify: %v", problems)
+	}
+	checkRanges(t, r, true)
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("重复 Close: %v", err)
+	}
+	if runtime.GOOS == "linux" {
+		if _, err := r.Find(netip.MustParseAddr("1.0.0.1")); !errors.Is(err, ErrClosed) {
+			t.Errorf("Close 之后 Find = %v，期望 ErrClosed", err)
+		}
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err = NewReaderAt(f, int64(len(data)), WithVerify())
+	if err != nil {
+		t.Fatalf("NewReaderAt: %v", err)
+	}
+	if problems := r.Verify(); len(problems) > 0 {
+		t.Fatalf("Verify: %v", problems)
+	}
+	checkRanges(t, r, true)
+}
+
+// TestChecksum 修改任意一个字节后 WithVerify 打开失败
+func TestChecksum(t *testing.T) {
+	data := buildTestFile(t, false)
+	data[len(data)/2] ^= 0xFF
+	if _, err := NewReader(data, WithVerify()); !errors.Is(err, ErrChecksum) {
+		t.Errorf("NewReader = %v，期望 ErrChecksum", err)
+	}
+}
+
+// TestRanges 按顺序遍历全部记录，IPv6 文件中的 IPv4 记录以 IPv4 地址给出
+func TestRanges(t *testing.T) {
+	for _, opts := range [][]BuilderOption{nil, {WithColumnDict(), WithCompression()}} {
+		data := buildTestFile(t, true, opts...)
+		r, err := NewReader(data)
+		if err != nil {
+			t.Fatalf("NewReader: %v", err)
+		}
+		want := make(map[string]string)
+		for _, tr := range testRanges(true) {
+			want[tr.start+"-"+tr.end] = tr.payload
+		}
+		count := 0
+		var prevEnd netip.Addr
+		err = r.Ranges(func(start, end netip.Addr, payload string) error {
+			if count > 0 && !prevEnd.Less(start) {
+				t.Errorf("第 %d 条 %s 未排序，上一条结束于 %s", count, start, prevEnd)
+			}
+			if p, ok := want[start.String()+"-"+end.String()]; ok {
+				if p != payload {
+					t.Errorf("%s-%s = %.40q，期望 %.40q", start, end, payload, p)
+				}
+				delete(want, start.String()+"-"+end.String())
+			}
+			prevEnd = end
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Ranges: %v", err)
+		}
+		if wantCount := fillerCount + len(testRanges(true)); count != wantCount {
+			t.Errorf("遍历 %d 条，期望 %d 条", count, wantCount)
+		}
+		for k := range want {
+			t.Errorf("未遍历到 %s", k)
+		}
+
+		stop := errors.New("stop")
+		count = 0
+		err = r.Ranges(func(netip.Addr, netip.Addr, string) error {
+			count++
+			return stop
+		})
+		if err != stop || count != 1 {
+			t.Errorf("Ranges = %v，调用 %d 次，期望在第一次返回错误时停止", err, count)
+		}
+	}
+}
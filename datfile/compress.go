@@ -0,0 +1,243 @@
+package datfile
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// compressBlockSize 压缩前每块的目标大小，超过该大小的单条内容独占一块
+	compressBlockSize = 8 << 10
+	// maxBlocks 内容指针高 16 位为块序号，低 16 位为块内偏移
+	maxBlocks = 1 << 16
+	// defaultBlockCache 默认缓存的解压块数
+	defaultBlockCache = 64
+)
+
+// blockStore 压缩后的内容块，设置了内存限制时存放在临时文件中
+type blockStore struct {
+	buf   bytes.Buffer
+	file  *os.File
+	sizes []uint32 // 每块压缩后的字节数
+	size  uint64
+}
+
+func (s *blockStore) writer() io.Writer {
+	if s.file != nil {
+		return s.file
+	}
+	return &s.buf
+}
+
+// tableSize 返回块表的字节数：4 字节块数和每块起始偏移，最后多一项结束偏移
+func (s *blockStore) tableSize() uint64 {
+	return 4 + 4*uint64(len(s.sizes)+1)
+}
+
+// writeTo 写出块表和全部压缩块，base 为块表在文件中的偏移
+func (s *blockStore) writeTo(w io.Writer, base uint32) error {
+	table := make([]byte, s.tableSize())
+	binary.LittleEndian.PutUint32(table[0:4], uint32(len(s.sizes)))
+	offset := base + uint32(len(table))
+	for i, size := range s.sizes {
+		binary.LittleEndian.PutUint32(table[4+4*i:], offset)
+		offset += size
+	}
+	binary.LittleEndian.PutUint32(table[4+4*len(s.sizes):], offset)
+	if _, err := w.Write(table); err != nil {
+		return err
+	}
+	if s.file == nil {
+		_, err := w.Write(s.buf.Bytes())
+		return err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(s.file, 0, int64(s.size))); err != nil {
+		return fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	return nil
+}
+
+func (s *blockStore) close() {
+	if s.file != nil {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+	}
+}
+
+// compressPayloads 按添加顺序把内容（带 uvarint 长度）切分成块并分别压缩，返回每条内容的指针
+func (b *Builder) compressPayloads() (*blockStore, []uint32, error) {
+	store := &blockStore{}
+	if b.maxMemory > 0 {
+		f, err := os.CreateTemp(b.tempDir, "ip2dat-block-*")
+		if err != nil {
+			return store, nil, fmt.Errorf("创建临时文件失败: %w", err)
+		}
+		store.file = f
+	}
+
+	fw, err := flate.NewWriter(nil, flate.BestCompression)
+	if err != nil {
+		return store, nil, err
+	}
+	var block []byte
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		out := &countingWriter{w: store.writer()}
+		fw.Reset(out)
+		if _, err := fw.Write(block); err != nil {
+			return err
+		}
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		store.sizes = append(store.sizes, uint32(out.n))
+		store.size += uint64(out.n)
+		block = block[:0]
+		return nil
+	}
+
+	pointers := make([]uint32, 0, b.payloads.len())
+	lengthBytes := make([]byte, binary.MaxVarintLen64)
+	err = b.payloads.each(func(text []byte) error {
+		n := binary.PutUvarint(lengthBytes, uint64(len(text)))
+		if len(block) > 0 && len(block)+n+len(text) > compressBlockSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(store.sizes) >= maxBlocks {
+			return fmt.Errorf("压缩块数超出上限 %d", maxBlocks)
+		}
+		pointers = append(pointers, uint32(len(store.sizes))<<16|uint32(len(block)))
+		block = append(block, lengthBytes[:n]...)
+		block = append(block, text...)
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return store, pointers, err
+}
+
+// parseBlockTable 解析 offset 处的块表
+func (r *Reader) parseBlockTable(offset uint32) error {
+	end := uint64(r.dataEnd())
+	if uint64(offset) < r.indexEnd() || uint64(offset)+4 > end {
+		return fmt.Errorf("%w: 块表 %d，文件长度 %d", ErrBadOffset, offset, r.size)
+	}
+	b, err := r.read(offset, 4)
+	if err != nil {
+		return err
+	}
+	count := binary.LittleEndian.Uint32(b)
+	tableEnd := uint64(offset) + 4 + 4*(uint64(count)+1)
+	if tableEnd > end {
+		return fmt.Errorf("%w: %d 个压缩块超出文件长度 %d", ErrBadOffset, count, r.size)
+	}
+	table, err := r.read(offset+4, 4*(count+1))
+	if err != nil {
+		return err
+	}
+	r.blockOffsets = make([]uint32, count+1)
+	prev := uint32(tableEnd)
+	for i := range r.blockOffsets {
+		o := binary.LittleEndian.Uint32(table[4*i:])
+		if o < prev || uint64(o) > end {
+			return fmt.Errorf("%w: 第 %d 个压缩块偏移 %d", ErrBadOffset, i, o)
+		}
+		r.blockOffsets[i] = o
+		prev = o
+	}
+	return nil
+}
+
+// payloadAt 解压指针所在的块并取出内容，返回的切片引用缓存中的块，不能修改
+func (r *Reader) payloadAt(pointer uint32) ([]byte, error) {
+	index, offset := pointer>>16, pointer&0xFFFF
+	block, err := r.block(index)
+	if err != nil {
+		return nil, err
+	}
+	if int(offset) >= len(block) {
+		return nil, fmt.Errorf("%w: 第 %d 块偏移 %d", ErrBadOffset, index, offset)
+	}
+	length, n := binary.Uvarint(block[offset:])
+	if n <= 0 || length > uint64(len(block)-int(offset)-n) {
+		return nil, fmt.Errorf("%w: 第 %d 块偏移 %d 的内容长度无法解码", ErrBadOffset, index, offset)
+	}
+	start := int(offset) + n
+	return block[start : start+int(length)], nil
+}
+
+// block 返回解压后的第 index 块，优先从缓存中取
+func (r *Reader) block(index uint32) ([]byte, error) {
+	if int(index)+1 >= len(r.blockOffsets) {
+		return nil, fmt.Errorf("%w: 压缩块 %d，共 %d 块", ErrBadOffset, index, len(r.blockOffsets)-1)
+	}
+	if data, ok := r.cache.get(index); ok {
+		return data, nil
+	}
+	raw, err := r.read(r.blockOffsets[index], r.blockOffsets[index+1]-r.blockOffsets[index])
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: 第 %d 块解压失败: %v", ErrBadFormat, index, err)
+	}
+	r.cache.add(index, data)
+	return data, nil
+}
+
+// blockCache 解压块的 LRU 缓存，可以在多个 goroutine 中使用
+type blockCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // 最近使用的在前
+	items    map[uint32]*list.Element
+}
+
+type cachedBlock struct {
+	index uint32
+	data  []byte
+}
+
+func newBlockCache(capacity int) *blockCache {
+	return &blockCache{capacity: capacity, order: list.New(), items: make(map[uint32]*list.Element)}
+}
+
+func (c *blockCache) get(index uint32) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[index]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedBlock).data, true
+}
+
+func (c *blockCache) add(index uint32, data []byte) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[index]; ok {
+		return
+	}
+	c.items[index] = c.order.PushFront(&cachedBlock{index: index, data: data})
+	for c.order.Len() > c.capacity {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*cachedBlock).index)
+	}
+}
@@ -0,0 +1,158 @@
+package datfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// columnSeparator 内容中列之间的分隔符
+const columnSeparator = "|"
+
+// columnDict 按列去重的字符串字典。内容按 | 分列后，每列存为该列字典中的序号
+type columnDict struct {
+	columns []dictColumn
+	memory  int64 // 估算的内存占用
+}
+
+type dictColumn struct {
+	values []string
+	index  map[string]uint32
+}
+
+// encode 把内容编码为一行：uvarint 列数，之后每列一个 uvarint 序号
+func (d *columnDict) encode(payload string) string {
+	fields := strings.Split(payload, columnSeparator)
+	buf := make([]byte, 0, binary.MaxVarintLen32*(len(fields)+1))
+	buf = appendUvarint(buf, uint64(len(fields)))
+	for i, field := range fields {
+		if i == len(d.columns) {
+			d.columns = append(d.columns, dictColumn{index: make(map[string]uint32)})
+		}
+		column := &d.columns[i]
+		idx, exists := column.index[field]
+		if !exists {
+			idx = uint32(len(column.values))
+			column.index[field] = idx
+			column.values = append(column.values, field)
+			d.memory += int64(len(field))*2 + 64
+		}
+		buf = appendUvarint(buf, uint64(idx))
+	}
+	return string(buf)
+}
+
+// size 返回字典写出后的字节数
+func (d *columnDict) size() uint64 {
+	var buf [binary.MaxVarintLen64]byte
+	n := uint64(binary.PutUvarint(buf[:], uint64(len(d.columns))))
+	for _, column := range d.columns {
+		n += uint64(binary.PutUvarint(buf[:], uint64(len(column.values))))
+		for _, value := range column.values {
+			n += uint64(binary.PutUvarint(buf[:], uint64(len(value)))) + uint64(len(value))
+		}
+	}
+	return n
+}
+
+// writeTo 写出字典：uvarint 列数，之后每列为 uvarint 条目数和各条目（uvarint 长度 + 内容）
+func (d *columnDict) writeTo(w io.Writer) error {
+	buf := appendUvarint(nil, uint64(len(d.columns)))
+	for _, column := range d.columns {
+		buf = appendUvarint(buf, uint64(len(column.values)))
+		for _, value := range column.values {
+			buf = appendUvarint(buf, uint64(len(value)))
+			buf = append(buf, value...)
+			if len(buf) >= 1<<16 {
+				if _, err := w.Write(buf); err != nil {
+					return err
+				}
+				buf = buf[:0]
+			}
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// parseDict 从 src 读取并解析列字典，size 为列字典最多可能占用的字节数
+func parseDict(src *bufio.Reader, size uint32) ([][]string, error) {
+	next := func() (uint64, error) {
+		v, err := binary.ReadUvarint(src)
+		if err != nil {
+			return 0, fmt.Errorf("%w: 列字典无法解码: %v", ErrBadOffset, err)
+		}
+		return v, nil
+	}
+	columnCount, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if columnCount > uint64(size) {
+		return nil, fmt.Errorf("%w: 列字典列数 %d", ErrBadOffset, columnCount)
+	}
+	dict := make([][]string, columnCount)
+	for i := range dict {
+		valueCount, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if valueCount > uint64(size) {
+			return nil, fmt.Errorf("%w: 列字典第 %d 列条目数 %d", ErrBadOffset, i, valueCount)
+		}
+		for j := uint64(0); j < valueCount; j++ {
+			length, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if length > uint64(size) {
+				return nil, fmt.Errorf("%w: 列字典第 %d 列第 %d 条长度 %d", ErrBadOffset, i, j, length)
+			}
+			value := make([]byte, length)
+			if _, err := io.ReadFull(src, value); err != nil {
+				return nil, fmt.Errorf("%w: 列字典第 %d 列第 %d 条: %v", ErrBadOffset, i, j, err)
+			}
+			dict[i] = append(dict[i], string(value))
+		}
+	}
+	return dict, nil
+}
+
+// decodeRow 把一行列序号解码为各列内容
+func (r *Reader) decodeRow(row []byte) ([]string, error) {
+	next := func() (uint64, error) {
+		v, n := binary.Uvarint(row)
+		if n <= 0 {
+			return 0, fmt.Errorf("%w: 内容无法解码", ErrBadOffset)
+		}
+		row = row[n:]
+		return v, nil
+	}
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if count > uint64(len(r.dict)) {
+		return nil, fmt.Errorf("%w: 内容列数 %d 超出列字典列数 %d", ErrBadOffset, count, len(r.dict))
+	}
+	fields := make([]string, count)
+	for i := range fields {
+		idx, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if idx >= uint64(len(r.dict[i])) {
+			return nil, fmt.Errorf("%w: 第 %d 列序号 %d 超出列字典条目数 %d", ErrBadOffset, i, idx, len(r.dict[i]))
+		}
+		fields[i] = r.dict[i][idx]
+	}
+	return fields, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
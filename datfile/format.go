@@ -0,0 +1,200 @@
+// Package datfile 实现 .dat 文件格式的读写，ip2loc、ip2asn、iplocsearch、
+// ipasnsearch 在此之上处理各自的数据集。
+//
+// 文件结构依次为文件头、前缀区、索引区、内容区和可选的校验和。
+//
+// 文件头（小端序，共 48 字节）：
+//
+//	0:4   索引区起始偏移
+//	4:8   魔数 "IPDT"（旧版文件为 0，IPv6 旧版为 6）
+//	8:12  前缀区起始偏移
+//	12:16 前缀区结束偏移
+//	16:18 格式版本
+//	18    数据集类型
+//	19    标志位
+//	20:24 索引记录数
+//	24:28 内容条目数
+//	28:36 生成时间（Unix 秒）
+//	36:40 列字典偏移
+//	40:44 块表偏移
+//	44:48 保留
+//
+// 前缀区为 256 * 9 字节：1 字节前缀、4 字节起始索引、4 字节结束索引。
+// IPv4 以 IP 的第一个八位字节为前缀，IPv6 以 16 字节形式的第一个字节为前缀。
+// 标志位 flagPrefixSpan 置位时，跨越多个前缀的记录登记在它覆盖的每个前缀中，
+// 没有记录的前缀起止索引均为 0xFFFFFFFF；未置位的旧文件只按起始 IP 登记，
+// 空前缀为 0-0。
+//
+// 索引区每条记录为起始 IP、结束 IP、4 字节内容偏移。IPv4 格式的 IP 为 4 字节
+// 小端整数（共 12 字节），IPv6 格式的 IP 为 16 字节网络字节序（共 36 字节），
+// IPv6 格式中 IPv4 记录以 IPv4 映射地址存储。内容区每条内容前有 uvarint 编码的
+// 长度，内容偏移指向长度的第一个字节。
+//
+// 标志位 flagColumnDict 置位时内容区开头为列字典：uvarint 列数，之后每列为
+// uvarint 条目数和各条目（uvarint 长度 + 内容）。此时每条内容按 | 分列，
+// 存为 uvarint 列数和每列在该列字典中的 uvarint 序号。
+//
+// 标志位 flagCompressed 置位时内容（含 uvarint 长度）按添加顺序切分成约 8KB 的块，
+// 每块单独用 DEFLATE 压缩。块表为 4 字节块数和每块的起始偏移，最后多一项结束偏移；
+// 索引记录中的内容偏移高 16 位为块序号，低 16 位为解压后的块内偏移。
+//
+// 版本 1 及旧版文件的索引记录在内容偏移后还有 1 字节内容长度（共 13 / 37 字节），
+// 内容区没有长度前缀，内容最长 255 字节。
+//
+// 标志位 flagChecksum 置位时文件末尾追加 4 字节 CRC32C，覆盖其之前的全部内容。
+package datfile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// Kind 数据集类型
+type Kind uint8
+
+const (
+	KindUnknown  Kind = 0 // 旧版无版本号文件
+	KindLocation Kind = 1
+	KindASN      Kind = 2
+	KindCustom   Kind = 3
+)
+
+// 文件头常量，Version 为当前写入和支持的最高格式版本
+const (
+	Version        = 2
+	headerSize     = 48
+	prefixCount    = 256
+	prefixSize     = 9
+	flagIPv6       = 1 << 0
+	flagChecksum   = 1 << 1
+	flagPrefixSpan = 1 << 2
+	flagColumnDict = 1 << 3
+	flagCompressed = 1 << 4
+	knownFlags     = flagIPv6 | flagChecksum | flagPrefixSpan | flagColumnDict | flagCompressed
+)
+
+// emptyBucket 没有记录的前缀在前缀区中的起止索引
+const emptyBucket = ^uint32(0)
+
+var (
+	datMagic    = []byte("IPDT")
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+var (
+	// ErrKindMismatch 文件的数据集类型与期望不符
+	ErrKindMismatch = errors.New("数据集类型不匹配")
+	// ErrNotFound 文件中没有覆盖该 IP 的记录
+	ErrNotFound = errors.New("未找到 IP 记录")
+	// ErrInvalidIP 查询的字符串不是合法的 IP 地址
+	ErrInvalidIP = errors.New("无效的 IP")
+	// ErrTooShort 文件长度不足以容纳文件头
+	ErrTooShort = errors.New("文件长度不足")
+	// ErrBadFormat 魔数无法识别，不是 .dat 文件
+	ErrBadFormat = errors.New("无法识别的文件格式")
+	// ErrUnsupportedVersion 文件格式版本高于当前支持的版本
+	ErrUnsupportedVersion = errors.New("不支持的格式版本")
+	// ErrBadOffset 文件头或索引记录中的偏移超出文件范围
+	ErrBadOffset = errors.New("偏移越界")
+	// ErrCorruptPrefixTable 前缀区索引颠倒或超出记录数
+	ErrCorruptPrefixTable = errors.New("前缀区损坏")
+	// ErrChecksum 校验和不匹配
+	ErrChecksum = errors.New("校验和不匹配")
+	// ErrClosed Reader 已经关闭
+	ErrClosed = errors.New("文件已关闭")
+)
+
+// Header 文件头信息，旧版文件 Version 为 0，只有 IPv6 字段有效
+type Header struct {
+	Version      uint16
+	Kind         Kind
+	IPv6         bool
+	Checksum     bool // 文件末尾带 CRC32C 校验和
+	ColumnDict   bool // 内容按列字典编码
+	Compressed   bool // 内容分块压缩
+	RecordCount  uint32
+	PayloadCount uint32
+	BuildTime    time.Time
+}
+
+func parseHeader(data []byte) (Header, error) {
+	var h Header
+	if len(data) < 16 {
+		return h, fmt.Errorf("%w: %d 字节", ErrTooShort, len(data))
+	}
+	switch binary.LittleEndian.Uint32(data[4:8]) {
+	case 0:
+		return h, nil
+	case 6:
+		h.IPv6 = true
+		return h, nil
+	}
+	if string(data[4:8]) != string(datMagic) {
+		return h, ErrBadFormat
+	}
+	if len(data) < headerSize {
+		return h, fmt.Errorf("%w: 文件头只有 %d 字节", ErrTooShort, len(data))
+	}
+	h.Version = binary.LittleEndian.Uint16(data[16:18])
+	if h.Version == 0 || h.Version > Version {
+		return h, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+	h.Kind = Kind(data[18])
+	if data[19]&^knownFlags != 0 {
+		return h, fmt.Errorf("%w: 未知的标志位 %08b", ErrUnsupportedVersion, data[19])
+	}
+	h.IPv6 = data[19]&flagIPv6 != 0
+	h.Checksum = data[19]&flagChecksum != 0
+	h.ColumnDict = data[19]&flagColumnDict != 0
+	h.Compressed = data[19]&flagCompressed != 0
+	h.RecordCount = binary.LittleEndian.Uint32(data[20:24])
+	h.PayloadCount = binary.LittleEndian.Uint32(data[24:28])
+	h.BuildTime = time.Unix(int64(binary.LittleEndian.Uint64(data[28:36])), 0)
+	return h, nil
+}
+
+func putHeader(data []byte, h Header, indexOffset, prefixStart, prefixEnd, dictOffset, blockTableOffset uint32) {
+	binary.LittleEndian.PutUint32(data[0:4], indexOffset)
+	copy(data[4:8], datMagic)
+	binary.LittleEndian.PutUint32(data[8:12], prefixStart)
+	binary.LittleEndian.PutUint32(data[12:16], prefixEnd)
+	binary.LittleEndian.PutUint16(data[16:18], h.Version)
+	data[18] = byte(h.Kind)
+	data[19] = flagPrefixSpan
+	if h.IPv6 {
+		data[19] |= flagIPv6
+	}
+	if h.Checksum {
+		data[19] |= flagChecksum
+	}
+	if h.ColumnDict {
+		data[19] |= flagColumnDict
+	}
+	if h.Compressed {
+		data[19] |= flagCompressed
+	}
+	binary.LittleEndian.PutUint32(data[20:24], h.RecordCount)
+	binary.LittleEndian.PutUint32(data[24:28], h.PayloadCount)
+	binary.LittleEndian.PutUint64(data[28:36], uint64(h.BuildTime.Unix()))
+	binary.LittleEndian.PutUint32(data[36:40], dictOffset)
+	binary.LittleEndian.PutUint32(data[40:44], blockTableOffset)
+}
+
+// ipSize 返回索引记录中单个 IP 占用的字节数
+func ipSize(ipv6 bool) uint32 {
+	if ipv6 {
+		return 16
+	}
+	return 4
+}
+
+// recordSize 返回指定格式版本单条索引记录的字节数
+func recordSize(version uint16, ipv6 bool) uint32 {
+	if version < 2 {
+		return 2*ipSize(ipv6) + 5
+	}
+	return 2*ipSize(ipv6) + 4
+}
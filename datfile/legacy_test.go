@@ -0,0 +1,88 @@
+package datfile
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadLegacySource 读取旧版 .dat 的源文件：起止 IP 之后跳过两列整数 IP，其余列为内容
+func loadLegacySource(t *testing.T, name string) []testRange {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ranges []testRange
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "|", 5)
+		ranges = append(ranges, testRange{fields[0], fields[1], fields[4]})
+	}
+	return ranges
+}
+
+// TestLegacyFile testdata 中的 legacy.dat 由无版本号的原始转换工具生成，
+// legacy6.dat 由魔数为 6 的 IPv6 旧版转换工具生成，源文件为同名 .txt
+func TestLegacyFile(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ipv6 bool
+	}{
+		{"legacy", false},
+		{"legacy6", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Open(filepath.Join("testdata", tt.name+".dat"), WithVerify(), WithKind(KindLocation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if h := r.Header(); h != (Header{IPv6: tt.ipv6}) {
+				t.Errorf("Header = %+v", h)
+			}
+			if problems := r.Verify(); len(problems) > 0 {
+				t.Errorf("Verify: %v", problems)
+			}
+
+			want := loadLegacySource(t, filepath.Join("testdata", tt.name+".txt"))
+			var got []testRange
+			err = r.Ranges(func(start, end netip.Addr, payload string) error {
+				got = append(got, testRange{start.String(), end.String(), payload})
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Ranges: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("Ranges 返回 %d 条，期望 %d 条: %v", len(got), len(want), got)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("Ranges[%d] = %v，期望 %v", i, got[i], want[i])
+				}
+			}
+
+			for _, w := range want {
+				for _, ip := range []string{w.start, w.end} {
+					if payload, err := r.Find(netip.MustParseAddr(ip)); err != nil || payload != w.payload {
+						t.Errorf("Find(%s) = %q, %v，期望 %q", ip, payload, err, w.payload)
+					}
+				}
+			}
+			for _, ip := range []string{"1.0.8.0", "255.255.255.255"} {
+				if _, err := r.Find(netip.MustParseAddr(ip)); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Find(%s) = %v，期望 ErrNotFound", ip, err)
+				}
+			}
+			if _, err := r.Find(netip.MustParseAddr("2001:db8::1")); tt.ipv6 != (err == nil) {
+				t.Errorf("Find(2001:db8::1) = %v", err)
+			}
+		})
+	}
+}
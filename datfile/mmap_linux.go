@@ -0,0 +1,44 @@
+//go:build linux
+
+package datfile
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// OpenMmap 以只读方式把 .dat 文件映射到内存并解析，查询直接读取映射区域，
+// 多个进程打开同一文件时共享页缓存。使用完毕需调用 Close 解除映射，
+// 之后的查询返回 ErrClosed
+func OpenMmap(name string, opts ...Option) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	size := fi.Size()
+	if size == 0 {
+		return NewReader(nil, opts...)
+	}
+	if size != int64(int(size)) {
+		return nil, fmt.Errorf("文件过大，无法映射: %d 字节", size)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("映射文件失败: %w", err)
+	}
+	r, err := NewReader(data, opts...)
+	if err != nil {
+		_ = syscall.Munmap(data)
+		return nil, err
+	}
+	r.unmap = func() error {
+		return syscall.Munmap(data)
+	}
+	return r, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package datfile
+
+// OpenMmap 在 Linux 以外的平台上等同于 Open，文件内容读入内存
+func OpenMmap(name string, opts ...Option) (*Reader, error) {
+	return Open(name, opts...)
+}
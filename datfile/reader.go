@@ -0,0 +1,501 @@
+package datfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+type (
+	ipIndex struct {
+		startIp, endIp           netip.Addr
+		localOffset, localLength uint32
+	}
+	prefixIndex struct {
+		startIndex, endIndex uint32
+	}
+)
+
+// Reader 在 .dat 文件上按 IP 查询内容。文件可以在内存中，也可以通过 io.ReaderAt 按需读取
+type Reader struct {
+	data      []byte      // 内存中的文件内容，NewReaderAt 创建时为 nil
+	at        io.ReaderAt // 文件内容，内存中的文件为 bytes.Reader
+	size      int64
+	header    Header
+	prefixMap map[uint32]prefixIndex
+	ipv6      bool       // IPv6 格式：起止 IP 为 16 字节网络字节序
+	span      bool       // 跨前缀的记录登记在覆盖的每个前缀中，空前缀已标记
+	dict      [][]string // 列字典，内容未按列编码时为 nil
+	// 压缩块的起始偏移，最后一项为结束偏移，内容未压缩时为 nil
+	blockOffsets []uint32
+	cache        *blockCache
+	unmap        func() error // OpenMmap 打开时解除映射
+	firstStartIpOffset,
+	prefixStartOffset,
+	prefixEndOffset,
+	prefixCount,
+	recordCount,
+	recordSize uint32
+}
+
+// Option Open、NewReader 等构造函数的可选配置
+type Option func(*options)
+
+type options struct {
+	verify     bool
+	kinds      []Kind
+	blockCache int
+}
+
+// WithVerify 打开文件时校验 CRC32C 校验和，不带校验和的旧版文件不做校验
+func WithVerify() Option {
+	return func(o *options) {
+		o.verify = true
+	}
+}
+
+// WithBlockCache 设置压缩文件缓存的解压块数，默认 64 块（每块约 8KB），0 表示不缓存
+func WithBlockCache(n int) Option {
+	return func(o *options) {
+		o.blockCache = n
+	}
+}
+
+// WithKind 只接受指定数据集类型、KindCustom 和旧版文件，其它类型返回 ErrKindMismatch
+func WithKind(kind Kind) Option {
+	return func(o *options) {
+		o.kinds = append(o.kinds, kind)
+	}
+}
+
+// Open 读取并解析 .dat 文件，文件不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)，
+// 文件损坏时返回的错误包装 ErrTooShort、ErrBadFormat、ErrBadOffset 等哨兵错误
+func Open(name string, opts ...Option) (*Reader, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return NewReader(data, opts...)
+}
+
+// OpenFS 从 fsys 中读取并解析 .dat 文件，可用于 //go:embed 嵌入的文件，文件内容读入内存
+func OpenFS(fsys fs.FS, name string, opts ...Option) (*Reader, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return NewReader(data, opts...)
+}
+
+// NewReader 解析内存中的 .dat 文件内容，data 在 Reader 使用期间不能修改
+func NewReader(data []byte, opts ...Option) (*Reader, error) {
+	return newReader(data, bytes.NewReader(data), int64(len(data)), opts)
+}
+
+// NewReaderAt 解析 src 中长度为 size 的 .dat 文件。只有文件头、前缀区、列字典和块表
+// 读入内存，索引记录和内容在查询时按需读取，适合内存有限的环境。src 需支持并发读取
+func NewReaderAt(src io.ReaderAt, size int64, opts ...Option) (*Reader, error) {
+	return newReader(nil, src, size, opts)
+}
+
+func newReader(data []byte, at io.ReaderAt, size int64, opts []Option) (*Reader, error) {
+	o := options{blockCache: defaultBlockCache}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	r := Reader{}
+	r.data = data
+	r.at = at
+	r.size = size
+	r.prefixMap = make(map[uint32]prefixIndex)
+	if size > int64(^uint32(0)) {
+		return nil, fmt.Errorf("%w: 文件长度 %d 超出 4GB", ErrBadFormat, size)
+	}
+
+	head, err := r.read(0, uint32(size64Min(size, headerSize)))
+	if err != nil {
+		return nil, err
+	}
+	if r.header, err = parseHeader(head); err != nil {
+		return nil, err
+	}
+	if !o.acceptKind(r.header.Kind) {
+		return nil, fmt.Errorf("%w: %d", ErrKindMismatch, r.header.Kind)
+	}
+	if o.verify {
+		if err = r.verifyChecksum(); err != nil {
+			return nil, err
+		}
+	}
+
+	r.firstStartIpOffset = binary.LittleEndian.Uint32(head[0:4])
+	r.ipv6 = r.header.IPv6
+	r.span = r.header.Version > 0 && head[19]&flagPrefixSpan != 0
+	r.recordSize = recordSize(r.header.Version, r.ipv6)
+	r.prefixStartOffset = binary.LittleEndian.Uint32(head[8:12])
+	r.prefixEndOffset = binary.LittleEndian.Uint32(head[12:16])
+	if r.prefixEndOffset < r.prefixStartOffset {
+		return nil, fmt.Errorf("%w: 前缀区 %d-%d，文件长度 %d", ErrBadOffset, r.prefixStartOffset, r.prefixEndOffset, size)
+	}
+	r.prefixCount = (r.prefixEndOffset-r.prefixStartOffset)/prefixSize + 1
+	if uint64(r.prefixStartOffset)+uint64(r.prefixCount)*prefixSize > uint64(r.dataEnd()) {
+		return nil, fmt.Errorf("%w: 前缀区 %d-%d，文件长度 %d", ErrBadOffset, r.prefixStartOffset, r.prefixEndOffset, size)
+	}
+	if r.firstStartIpOffset < r.prefixStartOffset+r.prefixCount*prefixSize || r.firstStartIpOffset > r.dataEnd() {
+		return nil, fmt.Errorf("%w: 索引区 %d，文件长度 %d", ErrBadOffset, r.firstStartIpOffset, size)
+	}
+
+	indexBuffer, err := r.read(r.prefixStartOffset, r.prefixCount*prefixSize)
+	if err != nil {
+		return nil, err
+	}
+	for k := uint32(0); k < r.prefixCount; k++ {
+		entry := indexBuffer[k*prefixSize : (k+1)*prefixSize]
+		prefix := uint32(entry[0])
+		pf := prefixIndex{
+			startIndex: binary.LittleEndian.Uint32(entry[1:5]),
+			endIndex:   binary.LittleEndian.Uint32(entry[5:9]),
+		}
+		if pf.startIndex > pf.endIndex {
+			return nil, fmt.Errorf("%w: 前缀 %d 的索引 %d-%d", ErrCorruptPrefixTable, prefix, pf.startIndex, pf.endIndex)
+		}
+		r.prefixMap[prefix] = pf
+	}
+
+	// 旧版文件没有记录数，以前缀区中最大的结束索引推算
+	r.recordCount = r.header.RecordCount
+	if r.header.Version == 0 {
+		for _, pf := range r.prefixMap {
+			if pf.endIndex+1 > r.recordCount {
+				r.recordCount = pf.endIndex + 1
+			}
+		}
+	}
+	for prefix, pf := range r.prefixMap {
+		if r.span && pf.empty() {
+			continue
+		}
+		if r.recordCount > 0 && pf.endIndex >= r.recordCount {
+			return nil, fmt.Errorf("%w: 前缀 %d 的索引 %d-%d 超出记录数 %d", ErrCorruptPrefixTable, prefix, pf.startIndex, pf.endIndex, r.recordCount)
+		}
+	}
+	if r.indexEnd() > uint64(r.dataEnd()) {
+		return nil, fmt.Errorf("%w: %d 条记录超出文件长度 %d", ErrBadOffset, r.recordCount, size)
+	}
+
+	if r.header.ColumnDict {
+		dictOffset := binary.LittleEndian.Uint32(head[36:40])
+		if uint64(dictOffset) < r.indexEnd() || dictOffset > r.dataEnd() {
+			return nil, fmt.Errorf("%w: 列字典 %d，文件长度 %d", ErrBadOffset, dictOffset, size)
+		}
+		n := r.dataEnd() - dictOffset
+		if r.dict, err = parseDict(bufio.NewReader(io.NewSectionReader(r.at, int64(dictOffset), int64(n))), n); err != nil {
+			return nil, err
+		}
+	}
+	if r.header.Compressed {
+		if err = r.parseBlockTable(binary.LittleEndian.Uint32(head[40:44])); err != nil {
+			return nil, err
+		}
+		r.cache = newBlockCache(o.blockCache)
+	}
+	return &r, nil
+}
+
+func (o *options) acceptKind(kind Kind) bool {
+	if len(o.kinds) == 0 || kind == KindUnknown || kind == KindCustom {
+		return true
+	}
+	for _, k := range o.kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Close 解除 OpenMmap 建立的内存映射，之后的查询返回 ErrClosed。
+// Open 和 NewReader 创建的 Reader 无需关闭，调用 Close 不做任何事
+func (r *Reader) Close() error {
+	if r.unmap == nil {
+		return nil
+	}
+	unmap := r.unmap
+	r.unmap = nil
+	r.data, r.at = nil, nil
+	return unmap()
+}
+
+// Header 返回文件头信息
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// indexEnd 返回索引区的结束偏移
+func (r *Reader) indexEnd() uint64 {
+	return uint64(r.firstStartIpOffset) + uint64(r.recordCount)*uint64(r.recordSize)
+}
+
+// dataEnd 返回校验和之前的数据长度
+func (r *Reader) dataEnd() uint32 {
+	if r.header.Checksum && r.size >= 4 {
+		return uint32(r.size - 4)
+	}
+	return uint32(r.size)
+}
+
+// read 返回 offset 处的 n 个字节。内存中的文件直接返回切片，不能修改
+func (r *Reader) read(offset, n uint32) ([]byte, error) {
+	end := uint64(offset) + uint64(n)
+	if end > uint64(r.size) {
+		return nil, fmt.Errorf("%w: 读取 %d-%d，文件长度 %d", ErrBadOffset, offset, end, r.size)
+	}
+	if r.data != nil {
+		return r.data[offset:end:end], nil
+	}
+	if r.at == nil {
+		return nil, ErrClosed
+	}
+	buf := make([]byte, n)
+	if n == 0 {
+		return buf, nil
+	}
+	if m, err := r.at.ReadAt(buf, int64(offset)); m < len(buf) {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return buf, nil
+}
+
+func size64Min(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Find 查询 IP 对应的内容，IPv4 映射的 IPv6 地址按 IPv4 查询，未命中返回 ErrNotFound。
+// 内容按列字典编码时返回以 | 连接的各列
+func (r *Reader) Find(addr netip.Addr) (string, error) {
+	payload, err := r.findPayload(addr)
+	if err != nil {
+		return "", err
+	}
+	return r.payloadText(payload)
+}
+
+// payloadText 把原始内容转换为 Find 返回的字符串
+func (r *Reader) payloadText(payload []byte) (string, error) {
+	if r.dict == nil {
+		return string(payload), nil
+	}
+	fields, err := r.decodeRow(payload)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(fields, columnSeparator), nil
+}
+
+// FindColumns 与 Find 相同，返回按 | 分隔的各列。内容按列字典编码时直接从字典取出各列，无需拆分字符串
+func (r *Reader) FindColumns(addr netip.Addr) ([]string, error) {
+	payload, err := r.findPayload(addr)
+	if err != nil {
+		return nil, err
+	}
+	if r.dict == nil {
+		return strings.Split(string(payload), columnSeparator), nil
+	}
+	return r.decodeRow(payload)
+}
+
+// Ranges 按 IP 顺序对每条记录调用 fn，payload 与 Find 返回的内容相同。
+// IPv6 格式文件中的 IPv4 记录以 IPv4 地址给出。fn 返回错误时停止遍历并返回该错误
+func (r *Reader) Ranges(fn func(start, end netip.Addr, payload string) error) error {
+	for i := uint32(0); i < r.recordCount; i++ {
+		index := ipIndex{}
+		if err := index.getIndex(i, r); err != nil {
+			return err
+		}
+		local, err := index.getLocal(r)
+		if err != nil {
+			return err
+		}
+		payload, err := r.payloadText(local)
+		if err != nil {
+			return err
+		}
+		start, end := index.startIp, index.endIp
+		if start.Is4In6() && end.Is4In6() {
+			start, end = start.Unmap(), end.Unmap()
+		}
+		if err := fn(start, end, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPayload 查询 IP 对应的原始内容，返回的切片可能引用文件数据，不能修改
+func (r *Reader) findPayload(addr netip.Addr) ([]byte, error) {
+	if !addr.IsValid() {
+		return nil, ErrInvalidIP
+	}
+	intIP, ok := r.normalize(addr)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	prefix := prefixOf(intIP)
+
+	if pf, ok := r.prefixMap[prefix]; ok && !(r.span && pf.empty()) {
+		if payload, found, err := r.search(pf.startIndex, pf.endIndex, intIP); found || err != nil {
+			return payload, err
+		}
+	}
+	if r.span || r.recordCount == 0 {
+		return nil, ErrNotFound
+	}
+	// 旧文件只按起始 IP 登记前缀，跨前缀的记录不在查询 IP 的前缀中，退回全表二分查找
+	payload, found, err := r.search(0, r.recordCount-1, intIP)
+	if !found && err == nil {
+		err = ErrNotFound
+	}
+	return payload, err
+}
+
+// search 在 low-high 范围内查找覆盖 ip 的记录
+func (r *Reader) search(low, high uint32, ip netip.Addr) ([]byte, bool, error) {
+	var myIndex uint32
+	if low != high {
+		var err error
+		if myIndex, err = r.binarySearch(low, high, ip); err != nil {
+			return nil, false, err
+		}
+	} else {
+		myIndex = low
+	}
+
+	index := ipIndex{}
+	if err := index.getIndex(myIndex, r); err != nil {
+		return nil, false, err
+	}
+
+	if index.startIp.Compare(ip) <= 0 && index.endIp.Compare(ip) >= 0 {
+		payload, err := index.getLocal(r)
+		return payload, true, err
+	}
+	return nil, false, nil
+}
+
+// normalize 将查询 IP 转换为文件中的存储形式，IPv4 文件无法回答 IPv6 查询
+func (r *Reader) normalize(ip netip.Addr) (netip.Addr, bool) {
+	if r.ipv6 {
+		return netip.AddrFrom16(ip.As16()), true
+	}
+	ip = ip.Unmap()
+	return ip, ip.Is4()
+}
+
+func (r *Reader) binarySearch(low, high uint32, k netip.Addr) (uint32, error) {
+	var M uint32
+	for low <= high {
+		mid := (low + high) / 2
+		endIpNum, err := r.getEndIp(mid)
+		if err != nil {
+			return 0, err
+		}
+		if endIpNum.Compare(k) >= 0 {
+			M = mid
+			if mid == 0 {
+				break
+			}
+			high = mid - 1
+		} else {
+			low = mid + 1
+		}
+	}
+	return M, nil
+}
+
+// empty 前缀没有记录
+func (p prefixIndex) empty() bool {
+	return p.startIndex == emptyBucket && p.endIndex == emptyBucket
+}
+
+func (r *Reader) getEndIp(left uint32) (netip.Addr, error) {
+	leftOffset := r.firstStartIpOffset + left*r.recordSize
+	size := ipSize(r.ipv6)
+	b, err := r.read(leftOffset+size, size)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return r.parseIp(b), nil
+}
+
+// parseIp 解析索引记录中的 IP：IPv4 为 4 字节小端整数，IPv6 为 16 字节网络字节序
+func (r *Reader) parseIp(data []byte) netip.Addr {
+	if r.ipv6 {
+		var b [16]byte
+		copy(b[:], data[:16])
+		return netip.AddrFrom16(b)
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], binary.LittleEndian.Uint32(data[:4]))
+	return netip.AddrFrom4(b)
+}
+
+func (p *ipIndex) getIndex(left uint32, r *Reader) error {
+	record, err := r.read(r.firstStartIpOffset+left*r.recordSize, r.recordSize)
+	if err != nil {
+		return err
+	}
+	size := ipSize(r.ipv6)
+	p.startIp = r.parseIp(record)
+	p.endIp = r.parseIp(record[size:])
+	record = record[2*size:]
+	p.localOffset = binary.LittleEndian.Uint32(record[0:4]) // 4字节偏移
+	if r.blockOffsets != nil {
+		// 压缩文件的内容偏移为块序号和块内偏移，由 getLocal 解压后取出内容
+		p.localLength = 0
+		return nil
+	}
+	if r.header.Version < 2 {
+		p.localLength = uint32(record[4])
+		return nil
+	}
+
+	// 内容前的 uvarint 长度，无法解码时长度置为最大值，由 getLocal 和 Verify 报告越界
+	p.localLength = ^uint32(0)
+	if p.localOffset >= r.dataEnd() {
+		return nil
+	}
+	n := r.dataEnd() - p.localOffset
+	if n > binary.MaxVarintLen32 {
+		n = binary.MaxVarintLen32
+	}
+	b, err := r.read(p.localOffset, n)
+	if err != nil {
+		return err
+	}
+	length, m := binary.Uvarint(b)
+	if m <= 0 || length > uint64(^uint32(0)) {
+		return nil
+	}
+	p.localOffset += uint32(m)
+	p.localLength = uint32(length)
+	return nil
+}
+
+func (p *ipIndex) getLocal(r *Reader) ([]byte, error) {
+	if r.blockOffsets != nil {
+		return r.payloadAt(p.localOffset)
+	}
+	if uint64(p.localOffset)+uint64(p.localLength) > uint64(r.dataEnd()) {
+		return nil, fmt.Errorf("%w: 内容偏移 %d，长度 %d", ErrBadOffset, p.localOffset, p.localLength)
+	}
+	return r.read(p.localOffset, p.localLength)
+}
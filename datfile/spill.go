@@ -0,0 +1,320 @@
+package datfile
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+)
+
+// spill 把内存中的记录排序后写入一个临时文件，临时文件过多时先把它们归并成一个
+func (b *Builder) spill() error {
+	sortRecords(b.records)
+	err := b.writeRun(func(fn func(record) error) error {
+		for _, r := range b.records {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.records = b.records[:0]
+
+	if len(b.runs) < maxRuns {
+		return nil
+	}
+	runs := b.runs
+	b.runs = nil
+	err = b.writeRun(func(fn func(record) error) error {
+		return mergeSources(runs, nil, fn)
+	})
+	for _, f := range runs {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// writeRun 创建一个临时文件，写入 each 按顺序给出的全部记录
+func (b *Builder) writeRun(each func(fn func(record) error) error) error {
+	f, err := os.CreateTemp(b.tempDir, "ip2dat-run-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	b.runs = append(b.runs, f)
+	w := bufio.NewWriterSize(f, 1<<16)
+	buf := make([]byte, recordMemSize)
+	err = each(func(r record) error {
+		encodeRecord(buf, r)
+		_, err := w.Write(buf)
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	return nil
+}
+
+func encodeRecord(buf []byte, r record) {
+	copy(buf[0:16], r.start[:])
+	copy(buf[16:32], r.end[:])
+	binary.LittleEndian.PutUint32(buf[32:36], r.payload)
+}
+
+func decodeRecord(buf []byte) record {
+	var r record
+	copy(r.start[:], buf[0:16])
+	copy(r.end[:], buf[16:32])
+	r.payload = binary.LittleEndian.Uint32(buf[32:36])
+	return r
+}
+
+// recordSource 一个有序记录段：内存中的记录或临时文件
+type recordSource struct {
+	records []record
+	reader  *bufio.Reader
+	buf     []byte
+	current record
+}
+
+func (s *recordSource) next() (bool, error) {
+	if s.reader == nil {
+		if len(s.records) == 0 {
+			return false, nil
+		}
+		s.current, s.records = s.records[0], s.records[1:]
+		return true, nil
+	}
+	if _, err := io.ReadFull(s.reader, s.buf); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	s.current = decodeRecord(s.buf)
+	return true, nil
+}
+
+// sourceHeap 按当前记录排序的最小堆
+type sourceHeap []*recordSource
+
+func (h sourceHeap) Len() int            { return len(h) }
+func (h sourceHeap) Less(i, j int) bool  { return recordLess(h[i].current, h[j].current) }
+func (h sourceHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sourceHeap) Push(x interface{}) { *h = append(*h, x.(*recordSource)) }
+func (h *sourceHeap) Pop() interface{} {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// merge 按顺序归并内存中的记录和全部临时文件，对每条记录调用 fn
+func (b *Builder) merge(fn func(record) error) error {
+	return mergeSources(b.runs, b.records, fn)
+}
+
+// mergeSources 按顺序归并有序的内存记录和临时文件，对每条记录调用 fn
+func mergeSources(runs []*os.File, records []record, fn func(record) error) error {
+	h := make(sourceHeap, 0, len(runs)+1)
+	sources := []*recordSource{{records: records}}
+	for _, f := range runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("读取临时文件失败: %w", err)
+		}
+		sources = append(sources, &recordSource{reader: bufio.NewReaderSize(f, 1<<16), buf: make([]byte, recordMemSize)})
+	}
+	for _, s := range sources {
+		ok, err := s.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, s)
+		}
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		s := h[0]
+		if err := fn(s.current); err != nil {
+			return err
+		}
+		ok, err := s.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// payloadStore 去重后的内容。内存模式下内容保存在内存中；
+// 临时文件模式下内容顺序写入临时文件，内存中只保留哈希、偏移和长度，
+// 哈希命中时读回临时文件比对，避免哈希冲突导致内容错误
+type payloadStore struct {
+	texts []string
+	index map[string]uint32
+
+	onDisk  bool
+	tempDir string
+	file    *os.File
+	w       *bufio.Writer
+	hashes  map[uint64]uint32
+	offsets []int64
+	lens    []uint32
+	size    int64
+
+	memory int64 // 估算的内存占用
+}
+
+func newPayloadStore(onDisk bool, tempDir string) *payloadStore {
+	return &payloadStore{
+		index:   make(map[string]uint32),
+		onDisk:  onDisk,
+		tempDir: tempDir,
+		hashes:  make(map[uint64]uint32),
+	}
+}
+
+func (p *payloadStore) add(text string) (uint32, error) {
+	if !p.onDisk {
+		if idx, exists := p.index[text]; exists {
+			return idx, nil
+		}
+		idx := uint32(len(p.texts))
+		p.index[text] = idx
+		p.texts = append(p.texts, text)
+		p.memory += int64(len(text))*2 + 64
+		return idx, nil
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(text))
+	sum := h.Sum64()
+	if idx, exists := p.hashes[sum]; exists && p.lens[idx] == uint32(len(text)) {
+		same, err := p.equal(idx, text)
+		if err != nil {
+			return 0, err
+		}
+		if same {
+			return idx, nil
+		}
+	}
+
+	if p.file == nil {
+		f, err := os.CreateTemp(p.tempDir, "ip2dat-payload-*")
+		if err != nil {
+			return 0, fmt.Errorf("创建临时文件失败: %w", err)
+		}
+		p.file = f
+		p.w = bufio.NewWriterSize(f, 1<<16)
+	}
+	if _, err := p.w.WriteString(text); err != nil {
+		return 0, fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	idx := uint32(len(p.lens))
+	if _, exists := p.hashes[sum]; !exists {
+		p.hashes[sum] = idx
+	}
+	p.offsets = append(p.offsets, p.size)
+	p.lens = append(p.lens, uint32(len(text)))
+	p.size += int64(len(text))
+	p.memory += 40
+	return idx, nil
+}
+
+// equal 比较临时文件中第 idx 条内容与 text 是否相同
+func (p *payloadStore) equal(idx uint32, text string) (bool, error) {
+	if err := p.w.Flush(); err != nil {
+		return false, fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	buf := make([]byte, p.lens[idx])
+	if _, err := p.file.ReadAt(buf, p.offsets[idx]); err != nil {
+		return false, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+	return bytes.Equal(buf, []byte(text)), nil
+}
+
+func (p *payloadStore) len() int {
+	if p.onDisk {
+		return len(p.lens)
+	}
+	return len(p.texts)
+}
+
+func (p *payloadStore) length(idx uint32) uint32 {
+	if p.onDisk {
+		return p.lens[idx]
+	}
+	return uint32(len(p.texts[idx]))
+}
+
+// writeTo 按添加顺序写出全部内容，每条内容前写 uvarint 编码的长度
+func (p *payloadStore) writeTo(w io.Writer) error {
+	lengthBytes := make([]byte, binary.MaxVarintLen64)
+	return p.each(func(text []byte) error {
+		n := binary.PutUvarint(lengthBytes, uint64(len(text)))
+		if _, err := w.Write(lengthBytes[:n]); err != nil {
+			return err
+		}
+		_, err := w.Write(text)
+		return err
+	})
+}
+
+// each 按添加顺序对每条内容调用 fn，text 只在调用期间有效
+func (p *payloadStore) each(fn func(text []byte) error) error {
+	if !p.onDisk {
+		for _, text := range p.texts {
+			if err := fn([]byte(text)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if p.file == nil {
+		return nil
+	}
+	if err := p.w.Flush(); err != nil {
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	r := bufio.NewReaderSize(io.NewSectionReader(p.file, 0, p.size), 1<<16)
+	var buf []byte
+	for _, length := range p.lens {
+		if cap(buf) < int(length) {
+			buf = make([]byte, length)
+		}
+		buf = buf[:length]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return fmt.Errorf("读取临时文件失败: %w", err)
+		}
+		if err := fn(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *payloadStore) close() error {
+	if p.file == nil {
+		return nil
+	}
+	_ = p.file.Close()
+	err := os.Remove(p.file.Name())
+	p.file = nil
+	return err
+}
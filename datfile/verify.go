@@ -0,0 +1,137 @@
+package datfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// 单个文件最多报告的问题数
+const maxProblems = 100
+
+// verifyChecksum 校验文件末尾的 CRC32C，不带校验和的文件直接通过
+func (r *Reader) verifyChecksum() error {
+	if !r.header.Checksum {
+		return nil
+	}
+	if r.size < 4 {
+		return fmt.Errorf("%w: 缺少校验和", ErrTooShort)
+	}
+	n := r.size - 4
+	b, err := r.read(uint32(n), 4)
+	if err != nil {
+		return err
+	}
+	want := binary.LittleEndian.Uint32(b)
+	crc := crc32.New(crc32cTable)
+	if r.data != nil {
+		_, _ = crc.Write(r.data[:n])
+	} else if _, err = io.Copy(crc, io.NewSectionReader(r.at, 0, n)); err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	if got := crc.Sum32(); got != want {
+		return fmt.Errorf("%w: 期望 %08x，实际 %08x", ErrChecksum, want, got)
+	}
+	return nil
+}
+
+// Verify 检查文件的完整性和结构：校验和、偏移越界、前缀区索引越界、
+// 记录未排序或重叠、记录未登记在覆盖的前缀中、内容指针越界、列序号越界，
+// 返回发现的全部问题（最多 100 条）
+func (r *Reader) Verify() []error {
+	var problems []error
+	report := func(format string, a ...interface{}) bool {
+		problems = append(problems, fmt.Errorf(format, a...))
+		return len(problems) < maxProblems
+	}
+
+	if err := r.verifyChecksum(); err != nil {
+		report("%w", err)
+	}
+
+	size := r.dataEnd()
+	recordCount := r.recordCount
+	indexEnd := r.indexEnd()
+
+	table, err := r.read(r.prefixStartOffset, r.prefixCount*prefixSize)
+	if err != nil {
+		report("%w", err)
+		return problems
+	}
+	for k := uint32(0); k < r.prefixCount; k++ {
+		prefix := uint32(table[k*prefixSize])
+		if prefix != k {
+			if !report("前缀区第 %d 项的前缀为 %d", k, prefix) {
+				return problems
+			}
+			continue
+		}
+		pf := r.prefixMap[prefix]
+		if r.span && pf.empty() {
+			continue
+		}
+		if recordCount > 0 && (pf.startIndex > pf.endIndex || pf.endIndex >= recordCount) {
+			if !report("前缀 %d 的索引越界: %d-%d，记录数 %d", prefix, pf.startIndex, pf.endIndex, recordCount) {
+				return problems
+			}
+		}
+	}
+
+	var prev ipIndex
+	for i := uint32(0); i < recordCount; i++ {
+		index := ipIndex{}
+		if err := index.getIndex(i, r); err != nil {
+			report("第 %d 条记录读取失败: %w", i, err)
+			return problems
+		}
+		if index.endIp.Less(index.startIp) {
+			if !report("第 %d 条记录起止 IP 颠倒: %s-%s", i, index.startIp, index.endIp) {
+				return problems
+			}
+		}
+		if i > 0 && !prev.endIp.Less(index.startIp) {
+			if !report("第 %d 条记录未排序或与上一条重叠: %s-%s，上一条 %s-%s", i, index.startIp, index.endIp, prev.startIp, prev.endIp) {
+				return problems
+			}
+		}
+		if r.span {
+			for prefix := prefixOf(index.startIp); prefix <= prefixOf(index.endIp) && !index.endIp.Less(index.startIp); prefix++ {
+				if pf := r.prefixMap[prefix]; pf.empty() || i < pf.startIndex || i > pf.endIndex {
+					if !report("第 %d 条记录未登记在前缀 %d 中", i, prefix) {
+						return problems
+					}
+				}
+			}
+		}
+		var payload []byte
+		if r.blockOffsets != nil {
+			var err error
+			if payload, err = r.payloadAt(index.localOffset); err != nil {
+				if !report("第 %d 条记录的内容指针越界: %w", i, err) {
+					return problems
+				}
+			}
+		} else if uint64(index.localOffset) < indexEnd || uint64(index.localOffset)+uint64(index.localLength) > uint64(size) {
+			if !report("第 %d 条记录的内容指针越界: 偏移 %d，长度 %d", i, index.localOffset, index.localLength) {
+				return problems
+			}
+		} else {
+			var err error
+			if payload, err = r.read(index.localOffset, index.localLength); err != nil {
+				if !report("第 %d 条记录的内容读取失败: %w", i, err) {
+					return problems
+				}
+			}
+		}
+		if payload != nil && r.dict != nil {
+			if _, err := r.decodeRow(payload); err != nil {
+				if !report("第 %d 条记录的内容无法解码: %w", i, err) {
+					return problems
+				}
+			}
+		}
+		prev = index
+	}
+	return problems
+}
@@ -0,0 +1,172 @@
+package datfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultWatchInterval 默认的文件检查间隔
+const defaultWatchInterval = 30 * time.Second
+
+// WatchOption NewWatcher 的可选配置
+type WatchOption func(*Watcher)
+
+// WithInterval 设置检查文件变化的间隔，默认 30 秒
+func WithInterval(d time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.interval = d
+	}
+}
+
+// WithOptions 设置每次加载文件时使用的 Reader 配置
+func WithOptions(opts ...Option) WatchOption {
+	return func(w *Watcher) {
+		w.opts = append(w.opts, opts...)
+	}
+}
+
+// OnReload 设置文件重新加载成功后的回调，在后台 goroutine 中调用
+func OnReload(fn func(Header)) WatchOption {
+	return func(w *Watcher) {
+		w.onReload = fn
+	}
+}
+
+// OnReloadError 设置文件变化后加载或校验失败的回调，在后台 goroutine 中调用，
+// 此时继续使用之前加载的文件
+func OnReloadError(fn func(error)) WatchOption {
+	return func(w *Watcher) {
+		w.onError = fn
+	}
+}
+
+// Watcher 定期检查 .dat 文件的修改时间、大小和 inode，文件变化且校验通过后原子替换 Reader。
+// 查询总是使用某一版完整加载的文件，不会看到加载到一半的状态。
+// 文件内容读入内存，旧版本在不再使用后由 GC 回收
+type Watcher struct {
+	name     string
+	opts     []Option
+	interval time.Duration
+	onReload func(Header)
+	onError  func(error)
+
+	current atomic.Value // *Reader
+	mu      sync.Mutex   // 串行化加载
+	stat    os.FileInfo  // 最近一次尝试加载时的文件信息，文件不存在时为 nil
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWatcher 加载文件并开始在后台检查文件变化，首次加载失败时返回错误。
+// 使用完毕需调用 Close 停止检查
+func NewWatcher(name string, opts ...WatchOption) (*Watcher, error) {
+	w := &Watcher{
+		name:     name,
+		interval: defaultWatchInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if w.interval <= 0 {
+		w.interval = defaultWatchInterval
+	}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Reader 返回当前使用的 Reader，每次查询前调用即可拿到最新加载的文件
+func (w *Watcher) Reader() *Reader {
+	return w.current.Load().(*Reader)
+}
+
+// Reload 立即重新加载文件，加载或校验失败时继续使用之前的 Reader 并返回错误，不调用回调
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stat, err := os.Stat(w.name)
+	if err != nil {
+		return fmt.Errorf("读取文件失败: %w", err)
+	}
+	w.stat = stat
+	_, err = w.load()
+	return err
+}
+
+// Close 停止检查文件变化，之后仍可使用最后加载的 Reader
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+	return nil
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check 文件变化时重新加载并调用回调。同一版文件加载失败只报告一次，
+// 文件继续写入或被替换后会再次尝试
+func (w *Watcher) check() {
+	w.mu.Lock()
+	var r *Reader
+	stat, err := os.Stat(w.name)
+	switch {
+	case err != nil:
+		if w.stat == nil {
+			err = nil
+		} else {
+			err = fmt.Errorf("读取文件失败: %w", err)
+		}
+		w.stat = nil
+	case w.stat != nil && sameFile(w.stat, stat):
+	default:
+		w.stat = stat
+		r, err = w.load()
+	}
+	w.mu.Unlock()
+
+	if err != nil && w.onError != nil {
+		w.onError(err)
+	}
+	if r != nil && w.onReload != nil {
+		w.onReload(r.Header())
+	}
+}
+
+// load 读取并校验文件，通过后替换当前 Reader
+func (w *Watcher) load() (*Reader, error) {
+	r, err := Open(w.name, append(w.opts, WithVerify())...)
+	if err != nil {
+		return nil, err
+	}
+	if problems := r.Verify(); len(problems) > 0 {
+		return nil, fmt.Errorf("文件校验失败，共 %d 个问题: %w", len(problems), problems[0])
+	}
+	w.current.Store(r)
+	return r, nil
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime()) && os.SameFile(a, b)
+}
@@ -0,0 +1,187 @@
+package datfile
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// watchFile 生成只有一个范围的文件，不同版本的内容不同
+func watchFile(t *testing.T, version string) []byte {
+	t.Helper()
+	b := NewBuilder(KindCustom)
+	defer b.Close()
+	if err := b.AddPrefix(netip.MustParsePrefix("1.0.0.0/24"), "版本|"+version); err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// replaceFile 先写临时文件再改名，与生成工具原子替换文件的方式相同
+func replaceFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.dat")
+	replaceFile(t, name, watchFile(t, "1"))
+
+	var mu sync.Mutex
+	var reloads []uint32
+	var reloadErrors []error
+	w, err := NewWatcher(name, WithInterval(2*time.Millisecond),
+		OnReload(func(h Header) {
+			mu.Lock()
+			reloads = append(reloads, h.RecordCount)
+			mu.Unlock()
+		}),
+		OnReloadError(func(err error) {
+			mu.Lock()
+			reloadErrors = append(reloadErrors, err)
+			mu.Unlock()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(reloads), len(reloadErrors)
+	}
+	find := func() string {
+		got, _ := w.Reader().Find(netip.MustParseAddr("1.0.0.1"))
+		return got
+	}
+
+	// 替换文件的同时不断查询，任何时候都只能看到某一版完整的文件
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				r := w.Reader()
+				got, err := r.Find(netip.MustParseAddr("1.0.0.1"))
+				if err != nil || (got != "版本|1" && got != "版本|2" && got != "版本|3") {
+					t.Errorf("Find = %q, %v", got, err)
+					return
+				}
+				if r.Header().RecordCount != 1 {
+					t.Errorf("Header = %+v", r.Header())
+					return
+				}
+			}
+		}()
+	}
+
+	// 改名替换
+	replaceFile(t, name, watchFile(t, "2"))
+	waitFor(t, "改名替换后重新加载", func() bool { n, _ := counts(); return n >= 1 })
+	if got := find(); got != "版本|2" {
+		t.Errorf("改名替换后 Find = %q", got)
+	}
+
+	// 原地写入一半：校验失败，继续使用之前的文件
+	v3 := watchFile(t, "3")
+	if err := os.WriteFile(name, v3[:len(v3)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "写入一半时报告错误", func() bool { _, n := counts(); return n >= 1 })
+	if got := find(); got != "版本|2" {
+		t.Errorf("写入一半后 Find = %q，期望继续使用之前的文件", got)
+	}
+	// 写完后重新加载
+	if err := os.WriteFile(name, v3, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "写完后重新加载", func() bool { return find() == "版本|3" })
+
+	close(stop)
+	wg.Wait()
+
+	// Close 之后不再检查文件变化
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("重复 Close: %v", err)
+	}
+	before, beforeErrors := counts()
+	replaceFile(t, name, watchFile(t, "4"))
+	time.Sleep(50 * time.Millisecond)
+	if n, e := counts(); n != before || e != beforeErrors {
+		t.Errorf("Close 之后仍调用回调: %d/%d 次重新加载，%d/%d 个错误", n, before, e, beforeErrors)
+	}
+	if got := find(); got != "版本|3" {
+		t.Errorf("Close 之后 Find = %q，期望最后加载的文件", got)
+	}
+	// Reload 失败时不调用回调，继续使用之前的文件
+	if err := os.WriteFile(name, []byte("bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reload(); err == nil {
+		t.Error("Reload 损坏的文件应返回错误")
+	}
+	if got := find(); got != "版本|3" {
+		t.Errorf("Reload 失败后 Find = %q", got)
+	}
+}
+
+// TestWatcherNilCallbacks 回调为 nil 时照常重新加载
+func TestWatcherNilCallbacks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.dat")
+	replaceFile(t, name, watchFile(t, "1"))
+	w, err := NewWatcher(name, WithInterval(2*time.Millisecond), OnReload(nil), OnReloadError(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	find := func() string {
+		got, _ := w.Reader().Find(netip.MustParseAddr("1.0.0.1"))
+		return got
+	}
+	if err := os.WriteFile(name, []byte("bad"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	replaceFile(t, name, watchFile(t, "2"))
+	waitFor(t, "重新加载", func() bool { return find() == "版本|2" })
+}
+
+func TestWatcherMissingFile(t *testing.T) {
+	if _, err := NewWatcher(filepath.Join(t.TempDir(), "missing.dat")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewWatcher = %v，期望 os.ErrNotExist", err)
+	}
+}
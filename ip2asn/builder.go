@@ -0,0 +1,44 @@
+package ip2asn
+
+import (
+	"io"
+	"net/netip"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/ipasnsearch"
+)
+
+// Builder 在代码中直接生成 ASN .dat 文件，无需先写出 CSV
+type Builder struct {
+	b *datfile.Builder
+}
+
+// NewBuilder 创建 ASN Builder
+func NewBuilder() *Builder {
+	return &Builder{b: datfile.NewBuilder(datfile.KindASN)}
+}
+
+// Add 添加一条 IP 范围和对应的 ASN 信息
+func (b *Builder) Add(start, end netip.Addr, rec ipasnsearch.ASNRecord) error {
+	return b.b.Add(start, end, rec.String())
+}
+
+// AddPrefix 以 rec.Prefix 作为 IP 范围添加 ASN 信息
+func (b *Builder) AddPrefix(rec ipasnsearch.ASNRecord) error {
+	return b.b.AddPrefix(rec.Prefix, rec.String())
+}
+
+// AddText 添加一条 IP 范围和竖线分隔的 ASN 信息字符串（ipRange|asn|组织名称）
+func (b *Builder) AddText(start, end netip.Addr, asn string) error {
+	return b.b.Add(start, end, asn)
+}
+
+// Len 返回已添加的记录数
+func (b *Builder) Len() int {
+	return b.b.Len()
+}
+
+// WriteTo 生成 .dat 文件内容并写入 w
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	return b.b.WriteTo(w)
+}
@@ -0,0 +1,82 @@
+package ip2asn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/billcoding/ip2dat/ipasnsearch"
+)
+
+// Dump 把 .dat 文件导出为 Convert 接受的格式，outputFile 以 .csv 结尾时导出每列带引号的 CSV，
+// 否则导出竖线分隔的 TXT
+func Dump(inputFile, outputFile string) (err error) {
+	s, err := ipasnsearch.New(inputFile)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	defer s.Close()
+	f, err := os.Create(outputFile)
+	if err != nil {
+		fmt.Println("导出文件失败:", err)
+		return err
+	}
+	w := bufio.NewWriterSize(f, 1<<16)
+	n, err := DumpTo(w, s, strings.HasSuffix(strings.ToLower(outputFile), ".csv"))
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Println("导出文件失败:", err)
+		return err
+	}
+	fmt.Printf("导出文件成功: %s，共 %d 条\n", outputFile, n)
+	return nil
+}
+
+// DumpTo 按 IP 顺序把 s 中的全部范围写到 w，返回写出的条数。每行为起止 IP 和 ipRange、asn、组织名称，
+// IPv4 起止 IP 写为十进制数值，IPv6 写为文本形式。csv 为 true 时写出 CSV，否则写出竖线分隔的 TXT
+func DumpTo(w io.Writer, s *ipasnsearch.Searcher, csv bool) (int, error) {
+	n := 0
+	err := s.Ranges(func(start, end netip.Addr, text string) error {
+		fields := append([]string{ipNum(start), ipNum(end)}, strings.SplitN(text, "|", 3)...)
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		var line string
+		if csv {
+			line = formatCSVLine(fields)
+		} else {
+			line = strings.Join(fields, "|")
+		}
+		n++
+		_, err := io.WriteString(w, line+"\n")
+		return err
+	})
+	return n, err
+}
+
+// ipNum 返回 tabular.ParseIP 接受的形式：IPv4 为十进制数值，IPv6 为文本形式
+func ipNum(addr netip.Addr) string {
+	if addr.Is6() {
+		return addr.String()
+	}
+	return new(big.Int).SetBytes(addr.AsSlice()).String()
+}
+
+// formatCSVLine 每列加引号，列中的引号写为两个引号
+func formatCSVLine(fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, ",")
+}
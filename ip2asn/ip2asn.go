@@ -1,37 +1,46 @@
 package ip2asn
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"net/netip"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/tabular"
 )
 
-// ipData 表示一条 IP 范围和对应的 ASN 信息
-type ipData struct {
-	StartIP uint32 // 起始 IP
-	EndIP   uint32 // 结束 IP
-	ASNIdx  uint32 // ASN 信息在数据区中的索引
-	prefix  uint32 // 前缀（IP 的第一个八位字节）
+// schema ASN 数据的各列。默认格式为 start|end|ipRange|asn|org，起止 IP 可以是十进制数值或 IP 文本
+var schema = tabular.Schema{
+	Fields: []string{"range", "asn", "org"},
+	Aliases: map[string][]string{
+		"range": {"ip_range", "cidr", "network", "prefix"},
+		"asn":   {"as_number", "asnum", "autonomous_system_number"},
+		"org":   {"organization", "as_name", "as_org", "name", "description", "autonomous_system_organization"},
+	},
+	Default:    tabular.Mapping{"start": "1", "end": "2", "range": "3", "asn": "4", "org": "5"},
+	Columns:    5,
+	MinColumns: 5,
 }
 
-// asnData 表示去重后的 ASN 信息
-type asnData struct {
-	Offset uint32 // 在数据区中的偏移量
-	Length uint32 // 信息的长度
-	Text   string // ASN 信息字符串（ipRange|asn|组织名称）
+// Convert 逐行读取输入文件并生成 .dat 文件，opts 可以用 datfile.WithMaxMemory 限制内存占用
+func Convert(inputFile, outputFile string, opts ...datfile.BuilderOption) error {
+	return ConvertColumns(inputFile, outputFile, nil, opts...)
 }
 
-func Convert(inputFile, outputFile string) (err error) {
-	ipDataList, asnList, err := loadIPDataFromFile(inputFile)
+// ConvertColumns 与 Convert 相同，按 columns 指定的列读取起止 IP 和 ASN 信息，
+// 列名为 start、end、range、asn 和 org。columns 为空时使用默认格式，第一行为表头时按列名自动映射
+func ConvertColumns(inputFile, outputFile string, columns tabular.Mapping, opts ...datfile.BuilderOption) (err error) {
+	b := datfile.NewBuilder(datfile.KindASN, opts...)
+	defer b.Close()
+	err = loadIPDataFromFile(inputFile, columns, b)
 	if err != nil {
 		fmt.Println("加载数据失败:", err)
 		return err
 	}
-	err = generateIPDat(outputFile, ipDataList, asnList)
+	err = generateIPDat(outputFile, b)
 	if err != nil {
 		fmt.Println("生成文件失败:", err)
 		return err
@@ -40,158 +49,92 @@ func Convert(inputFile, outputFile string) (err error) {
 	return
 }
 
-// 从文本行解析 CSV 格式的 ipData 和 asnData
-func parseCSVData(line string, asnMap map[string]uint32, asnList *[]asnData) (ipData, error) {
-	fields := strings.Split(line, ",")
-	if len(fields) < 5 { // 需要 5 个字段：startIPNum, endIPNum, ipRange, asn, org
-		return ipData{}, fmt.Errorf("CSV 字段不足: %s", line)
-	}
+// ipData 表示一条 IP 范围和对应的 ASN 信息
+type ipData struct {
+	StartIP netip.Addr // 起始 IP
+	EndIP   netip.Addr // 结束 IP
+	ASN     string     // ASN 信息字符串（ipRange|asn|组织名称）
+}
 
-	// 移除引号并填充到 5 个字段
-	for i, field := range fields {
-		fields[i] = strings.Trim(field, `"`)
+// parseRecord 解析一行数据，ipRange 为 IPv6 网段时起止 IP 的数值按 128 位解析
+func parseRecord(rec tabular.Record) (ipData, error) {
+	fields := make([]string, len(rec.Fields))
+	for i, field := range rec.Fields {
+		fields[i] = strings.TrimSpace(field)
 	}
-	for len(fields) < 5 {
-		fields = append(fields, "")
+	ipv6 := false
+	if prefix, err := netip.ParsePrefix(fields[0]); err == nil {
+		ipv6 = !prefix.Addr().Unmap().Is4()
 	}
-
-	// 解析 startIPNum 和 endIPNum 为 uint32
-	startIP, err := strconv.ParseUint(fields[0], 10, 32)
+	startIP, err := tabular.ParseIP(rec.Start, ipv6)
 	if err != nil {
-		return ipData{}, fmt.Errorf("无效的起始 IP: %s", fields[0])
+		return ipData{}, fmt.Errorf("无效的起始 IP: %s", rec.Start)
 	}
-	endIP, err := strconv.ParseUint(fields[1], 10, 32)
+	endIP, err := tabular.ParseIP(rec.End, ipv6 || startIP.Is6())
 	if err != nil {
-		return ipData{}, fmt.Errorf("无效的结束 IP: %s", fields[1])
+		return ipData{}, fmt.Errorf("无效的结束 IP: %s", rec.End)
 	}
 
 	// 拼接 ASN 信息（ipRange|asn|org）
-	asnInfo := strings.Join(fields[2:5], "|")
-
-	var asnIdx uint32
-	if offset, exists := asnMap[asnInfo]; exists {
-		asnIdx = offset
-	} else {
-		asnIdx = uint32(len(*asnList))
-		asnMap[asnInfo] = asnIdx
-		*asnList = append(*asnList, asnData{Text: asnInfo})
-	}
-
 	return ipData{
-		StartIP: uint32(startIP),
-		EndIP:   uint32(endIP),
-		ASNIdx:  asnIdx,
-		prefix:  uint32(startIP >> 24),
+		StartIP: startIP,
+		EndIP:   endIP,
+		ASN:     strings.Join(fields, "|"),
 	}, nil
 }
 
-// 从文件读取数据（仅支持 CSV）
-func loadIPDataFromFile(filename string) ([]ipData, []asnData, error) {
-	data, err := os.ReadFile(filename)
+// 从文件读取数据，支持竖线分隔的 TXT、CSV 和 TSV，无法解析的行打印后跳过
+func loadIPDataFromFile(filename string, columns tabular.Mapping, b *datfile.Builder) error {
+	f, err := os.Open(filename)
 	if err != nil {
-		return nil, nil, fmt.Errorf("读取文件失败: %v", err)
+		return fmt.Errorf("读取文件失败: %v", err)
 	}
+	defer f.Close()
 
-	lines := strings.Split(string(data), "\n")
-	var ipDataList []ipData
-	asnMap := make(map[string]uint32)
-	var asns []asnData
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	r, err := tabular.NewReader(f, filename, schema, columns)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var lineErr *tabular.LineError
+		if errors.As(err, &lineErr) {
+			fmt.Printf("解析错误: %v\n", err)
 			continue
 		}
-		data, err := parseCSVData(line, asnMap, &asns)
 		if err != nil {
-			fmt.Printf("解析错误: %v\n", err)
+			return err
+		}
+		data, err := parseRecord(rec)
+		if err == nil {
+			err = datfile.CheckRange(data.StartIP, data.EndIP)
+		}
+		if err != nil {
+			fmt.Printf("解析错误: 第 %d 行: %v\n", rec.Line, err)
 			continue
 		}
-		ipDataList = append(ipDataList, data)
+		if err = b.Add(data.StartIP, data.EndIP, data.ASN); err != nil {
+			return err
+		}
 	}
-	return ipDataList, asns, nil
 }
 
 // 生成数据文件
-func generateIPDat(filename string, ipDataList []ipData, asns []asnData) error {
-	sort.Slice(ipDataList, func(i, j int) bool {
-		return ipDataList[i].StartIP < ipDataList[j].StartIP
-	})
-
-	prefixMap := make(map[uint32][]int)
-	for i, ipData := range ipDataList {
-		prefixMap[ipData.prefix] = append(prefixMap[ipData.prefix], i)
-	}
-
-	var buffer bytes.Buffer
-	header := make([]byte, 16)
-	prefixStartOffset := uint32(16)
-	buffer.Write(header)
-
-	// 前缀区：256 * 9字节
-	for prefix := uint32(0); prefix < 256; prefix++ {
-		indices, exists := prefixMap[prefix]
-		var startIndex, endIndex uint32
-		if exists && len(indices) > 0 {
-			startIndex = uint32(indices[0])
-			endIndex = uint32(indices[len(indices)-1])
-		} else {
-			startIndex = 0
-			endIndex = 0
-		}
-		prefixBytes := []byte{byte(prefix)}
-		startBytes := make([]byte, 4)
-		endBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(startBytes, startIndex)
-		binary.LittleEndian.PutUint32(endBytes, endIndex)
-		buffer.Write(prefixBytes)
-		buffer.Write(startBytes)
-		buffer.Write(endBytes)
-	}
-	prefixEndOffset := uint32(buffer.Len()) - 1
-	firstStartIpOffset := prefixEndOffset + 1
-
-	// 索引区：13字节每条（4字节偏移）
-	dataOffset := firstStartIpOffset + uint32(len(ipDataList)*13)
-	for i := range asns {
-		asns[i].Offset = dataOffset
-		asns[i].Length = uint32(len(asns[i].Text))
-		dataOffset += asns[i].Length
-		if asns[i].Length > 255 {
-			fmt.Printf("警告：ASN信息长度超255字节：%d\n", asns[i].Length)
-		}
+func generateIPDat(filename string, b *datfile.Builder) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
-
-	for _, ipData := range ipDataList {
-		startIPBytes := make([]byte, 4)
-		endIPBytes := make([]byte, 4)
-		localOffsetBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(startIPBytes, ipData.StartIP)
-		binary.LittleEndian.PutUint32(endIPBytes, ipData.EndIP)
-		binary.LittleEndian.PutUint32(localOffsetBytes, asns[ipData.ASNIdx].Offset)
-		localLength := byte(asns[ipData.ASNIdx].Length)
-		if localLength == 0 {
-			localLength = 1
-		}
-		buffer.Write(startIPBytes)
-		buffer.Write(endIPBytes)
-		buffer.Write(localOffsetBytes) // 4字节偏移
-		buffer.WriteByte(localLength)
+	n, err := b.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-
-	// 内容区
-	for _, asn := range asns {
-		if asn.Text == "" {
-			buffer.WriteString("|")
-		} else {
-			buffer.WriteString(asn.Text)
-		}
+	if err != nil {
+		return err
 	}
-
-	result := buffer.Bytes()
-	binary.LittleEndian.PutUint32(result[0:4], firstStartIpOffset)
-	binary.LittleEndian.PutUint32(result[8:12], prefixStartOffset)
-	binary.LittleEndian.PutUint32(result[12:16], prefixEndOffset)
-	fmt.Printf("生成文件大小: %d 字节\n", len(result))
-	return os.WriteFile(filename, result, 0644)
+	fmt.Printf("生成文件大小: %d 字节\n", n)
+	return nil
 }
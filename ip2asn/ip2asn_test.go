@@ -0,0 +1,200 @@
+package ip2asn
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/ipasnsearch"
+	"github.com/billcoding/ip2dat/mmdb"
+	"github.com/billcoding/ip2dat/tabular"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line       string
+		start, end string
+		asn        string
+	}{
+		{"16777216|16777471|1.0.0.0/24|13335|CloudFlare Inc.", "1.0.0.0", "1.0.0.255", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"1.0.0.0|1.0.0.255|1.0.0.0/24|13335|Org|With|Pipes", "1.0.0.0", "1.0.0.255", "1.0.0.0/24|13335|Org|With|Pipes"},
+		{`"0","16777215","0.0.0.0/8","-","-"`, "0.0.0.0", "0.255.255.255", "0.0.0.0/8|-|-"},
+		{`"16777216","16777471","1.0.0.0/24","13335","CloudFlare, Inc."`, "1.0.0.0", "1.0.0.255", "1.0.0.0/24|13335|CloudFlare, Inc."},
+		{"1.0.0.0\t1.0.0.255\t1.0.0.0/24\t13335\tCloudFlare Inc.", "1.0.0.0", "1.0.0.255", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"42540766411282592856903984951653826560|42540766411282592856903984951653892095|2001:db8::/112|64496|Doc",
+			"2001:db8::", "2001:db8::ffff", "2001:db8::/112|64496|Doc"},
+		{"0|65535|::/112|-|-", "::", "::ffff", "::/112|-|-"},
+		{"2001:db8::|2001:db8::ffff|2001:db8::/112|64496|Doc", "2001:db8::", "2001:db8::ffff", "2001:db8::/112|64496|Doc"},
+	}
+	for _, tt := range tests {
+		data, err := parseLine(tt.line)
+		if err != nil {
+			t.Errorf("parseLine(%q): %v", tt.line, err)
+			continue
+		}
+		if data.StartIP != netip.MustParseAddr(tt.start) || data.EndIP != netip.MustParseAddr(tt.end) || data.ASN != tt.asn {
+			t.Errorf("parseLine(%q) = %s-%s %q，期望 %s-%s %q", tt.line, data.StartIP, data.EndIP, data.ASN, tt.start, tt.end, tt.asn)
+		}
+	}
+
+	for _, line := range []string{"1|2|3", "1", "1|2|1.0.0.0/24|13335", "x|1|1.0.0.0/24|1|a", "1|1.0.0.0.0|1.0.0.0/24|1|a"} {
+		if _, err := parseLine(line); err == nil {
+			t.Errorf("parseLine(%q) 期望返回错误", line)
+		}
+	}
+}
+
+// parseLine 用默认格式读取单行数据
+func parseLine(line string) (ipData, error) {
+	return parseInput(line, "input.txt")
+}
+
+// parseInput 用默认格式读取 input 的第一行数据，按 filename 和内容判断分隔符
+func parseInput(input, filename string) (ipData, error) {
+	r, err := tabular.NewReader(strings.NewReader(input), filename, schema, nil)
+	if err != nil {
+		return ipData{}, err
+	}
+	rec, err := r.Read()
+	if err != nil {
+		return ipData{}, err
+	}
+	return parseRecord(rec)
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		input, filename string
+		asn             string
+	}{
+		{"1|2|1.0.0.0/24|13335|Org", "a.csv", "1.0.0.0/24|13335|Org"},
+		{"1\t2\t1.0.0.0/24\t13335\tOrg, Inc.", "a.txt", "1.0.0.0/24|13335|Org, Inc."},
+		{`"1","2","1.0.0.0/24","13335","Org | Inc."`, "a.txt", "1.0.0.0/24|13335|Org | Inc."},
+		// 竖线分隔、组织名称中逗号比竖线多
+		{"1.0.0.0|1.0.0.255|1.0.0.0/24|13335|Cloudflare, Inc., US, CA, San Francisco, 94107\n" +
+			"1.0.1.0|1.0.1.255|1.0.1.0/24|-|-\n", "a.txt", "1.0.0.0/24|13335|Cloudflare, Inc., US, CA, San Francisco, 94107"},
+	}
+	for _, tt := range tests {
+		data, err := parseInput(tt.input, tt.filename)
+		if err != nil || data.ASN != tt.asn {
+			t.Errorf("parseInput(%q, %q) = %q, %v，期望 %q", tt.input, tt.filename, data.ASN, err, tt.asn)
+		}
+	}
+}
+
+func TestLoadMMDB(t *testing.T) {
+	w := mmdb.NewWriter()
+	for _, r := range []struct{ prefix, text string }{
+		{"1.0.0.0/25", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"1.0.0.128/25", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"1.0.1.0/24", "1.0.1.0/24|-|-"},
+		{"2001:db8::/112", "2001:db8::/112|64496|Org|With|Pipes"},
+	} {
+		record, err := ipasnsearch.ParseASNRecord(r.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.InsertPrefix(netip.MustParsePrefix(r.prefix), MMDBRecord(record)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(t.TempDir(), "test.mmdb")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	b := datfile.NewBuilder(datfile.KindASN)
+	defer b.Close()
+	if err := loadMMDB(filename, nil, b); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ipasnsearch.NewBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = s.Ranges(func(start, end netip.Addr, text string) error {
+		got = append(got, start.String()+"-"+end.String()+" "+text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// range 列为各自的网段，相邻网段不合并；未公告的网段跳过
+	want := []string{
+		"1.0.0.0-1.0.0.127 1.0.0.0/25|13335|CloudFlare Inc.",
+		"1.0.0.128-1.0.0.255 1.0.0.128/25|13335|CloudFlare Inc.",
+		"2001:db8::-2001:db8::ffff 2001:db8::/112|64496|Org With Pipes",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ranges = %q，期望 %q", got, want)
+	}
+}
+
+func TestExportMMDBTo(t *testing.T) {
+	b := datfile.NewBuilder(datfile.KindASN)
+	defer b.Close()
+	for _, r := range []struct{ prefix, text string }{
+		{"0.0.0.0/8", "0.0.0.0/8|-|-"},
+		{"1.0.0.0/24", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"1.0.1.0/24", "1.0.1.0/24|-|"},
+		{"1.0.2.0/24", "1.0.2.0/24|-|Reserved"},
+		{"1.0.3.0/24", "1.0.3.0/24|bad|x"},
+		{"1.0.4.0/24", "1.0.4.0/24|0|"},
+	} {
+		if err := b.AddPrefix(netip.MustParsePrefix(r.prefix), r.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ipasnsearch.NewBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := mmdb.NewWriter()
+	// 未公告且没有组织名称的范围跳过并计数，无法解析的范围不计入
+	n, skipped, err := ExportMMDBTo(w, s)
+	if err != nil || n != 3 || skipped != 2 {
+		t.Fatalf("ExportMMDBTo = %d, %d, %v，期望 3, 2", n, skipped, err)
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	r, err := mmdb.NewReader(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]interface{}{
+		"1.0.0.1": map[string]interface{}{"autonomous_system_number": uint32(13335), "autonomous_system_organization": "CloudFlare Inc."},
+		"1.0.2.1": map[string]interface{}{"autonomous_system_organization": "Reserved"},
+		"1.0.4.1": map[string]interface{}{"autonomous_system_number": uint32(0)},
+	} {
+		if got, err := r.Lookup(netip.MustParseAddr(ip)); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Lookup(%s) = %#v, %v，期望 %#v", ip, got, err, want)
+		}
+	}
+	for _, ip := range []string{"0.1.2.3", "1.0.1.1", "1.0.3.1"} {
+		if _, err := r.Lookup(netip.MustParseAddr(ip)); !errors.Is(err, mmdb.ErrNotFound) {
+			t.Errorf("Lookup(%s) = %v，期望 ErrNotFound", ip, err)
+		}
+	}
+}
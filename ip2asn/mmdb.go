@@ -0,0 +1,162 @@
+package ip2asn
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/ipasnsearch"
+	"github.com/billcoding/ip2dat/mmdb"
+	"github.com/billcoding/ip2dat/tabular"
+)
+
+// ExportMMDB 把 Convert 接受的 TXT、CSV 文件或生成的 .dat 文件导出为 MaxMind DB 文件，
+// 按 GeoLite2 ASN 数据库的结构写出，见 MMDBRecord。columns 与 ConvertColumns 相同，.dat 文件忽略
+func ExportMMDB(inputFile, outputFile string, columns tabular.Mapping, opts ...mmdb.WriterOption) (err error) {
+	s, err := loadSearcher(inputFile, columns)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	defer s.Close()
+	w := mmdb.NewWriter(append([]mmdb.WriterOption{
+		mmdb.WithDatabaseType("ip2dat-ASN"),
+		mmdb.WithDescription("en", "ip2dat ASN database"),
+	}, opts...)...)
+	n, skipped, err := ExportMMDBTo(w, s)
+	if err == nil {
+		err = writeMMDB(outputFile, w)
+	}
+	if err != nil {
+		fmt.Println("导出文件失败:", err)
+		return err
+	}
+	if skipped > 0 {
+		fmt.Printf("导出文件成功: %s，共 %d 条，跳过 %d 条未公告的范围\n", outputFile, n, skipped)
+	} else {
+		fmt.Printf("导出文件成功: %s，共 %d 条\n", outputFile, n)
+	}
+	return nil
+}
+
+// loadSearcher 打开 .dat 文件，或读取 TXT、CSV 文件后在内存中生成
+func loadSearcher(inputFile string, columns tabular.Mapping) (*ipasnsearch.Searcher, error) {
+	if strings.HasSuffix(strings.ToLower(inputFile), ".dat") {
+		return ipasnsearch.New(inputFile)
+	}
+	b := datfile.NewBuilder(datfile.KindASN)
+	defer b.Close()
+	if err := loadIPDataFromFile(inputFile, columns, b); err != nil {
+		return nil, err
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return ipasnsearch.NewBytes(data)
+}
+
+func writeMMDB(filename string, w *mmdb.Writer) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	n, err := w.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("生成文件大小: %d 字节\n", n)
+	return nil
+}
+
+// ExportMMDBTo 把 s 中的全部范围添加到 w，返回添加的条数和跳过的未公告范围条数。
+// 未公告且没有组织名称的范围在 MMDB 中没有记录，查询时与不在文件中的 IP 相同；
+// 无法解析的 ASN 信息打印后跳过，不计入 skipped
+func ExportMMDBTo(w *mmdb.Writer, s *ipasnsearch.Searcher) (n, skipped int, err error) {
+	err = s.Ranges(func(start, end netip.Addr, text string) error {
+		r, err := ipasnsearch.ParseASNRecord(text)
+		if err != nil {
+			fmt.Printf("解析错误: %s - %s: %v\n", start, end, err)
+			return nil
+		}
+		record := MMDBRecord(r)
+		if len(record) == 0 {
+			skipped++
+			return nil
+		}
+		n++
+		return w.Insert(start, end, record)
+	})
+	return n, skipped, err
+}
+
+// MMDBRecord 把 ASN 信息转为 GeoLite2 ASN 数据库的结构：autonomous_system_number 和
+// autonomous_system_organization，未公告时为空
+func MMDBRecord(r ipasnsearch.ASNRecord) map[string]interface{} {
+	record := make(map[string]interface{})
+	if r.Routed {
+		record["autonomous_system_number"] = r.ASN
+	}
+	if r.Organization != "" {
+		record["autonomous_system_organization"] = r.Organization
+	}
+	return record
+}
+
+// mmdbPaths ConvertMMDB 默认的 map 路径，与 MMDBRecord 写出的 GeoLite2 ASN 结构对应
+var mmdbPaths = map[string]string{
+	"asn": "autonomous_system_number",
+	"org": "autonomous_system_organization",
+}
+
+// ConvertMMDB 读取 MaxMind DB 文件生成 .dat 文件。paths 指定 range、asn、org 各列取值的 map 路径，
+// 以 . 分隔，数组用下标，未指定的列为空，未指定 range 时为网段本身；paths 为空时使用 GeoLite2 ASN 的路径。
+// asn 和 org 均为空的网段跳过
+func ConvertMMDB(inputFile, outputFile string, paths map[string]string, opts ...datfile.BuilderOption) (err error) {
+	b := datfile.NewBuilder(datfile.KindASN, opts...)
+	defer b.Close()
+	err = loadMMDB(inputFile, paths, b)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	err = generateIPDat(outputFile, b)
+	if err != nil {
+		fmt.Println("生成文件失败:", err)
+		return err
+	}
+	fmt.Printf("生成文件成功: %s\n", outputFile)
+	return
+}
+
+func loadMMDB(filename string, paths map[string]string, b *datfile.Builder) error {
+	if len(paths) == 0 {
+		paths = mmdbPaths
+	}
+	columns, err := mmdb.Columns(paths, schema.Fields)
+	if err != nil {
+		return err
+	}
+	r, err := mmdb.Open(filename)
+	if err != nil {
+		return err
+	}
+	return r.Ranges(func(prefix netip.Prefix, value interface{}) string {
+		fields := mmdb.Fields(value, columns)
+		if fields[1] == "" && fields[2] == "" {
+			return ""
+		}
+		if columns[0] == "" {
+			fields[0] = prefix.String()
+		}
+		for i, field := range fields {
+			fields[i] = strings.ReplaceAll(field, "|", " ")
+		}
+		return strings.Join(fields, "|")
+	}, b.Add)
+}
@@ -0,0 +1,44 @@
+package ip2loc
+
+import (
+	"io"
+	"net/netip"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/iplocsearch"
+)
+
+// Builder 在代码中直接生成地理位置 .dat 文件，无需先写出 TXT 或 CSV
+type Builder struct {
+	b *datfile.Builder
+}
+
+// NewBuilder 创建地理位置 Builder，各列使用列字典编码
+func NewBuilder() *Builder {
+	return &Builder{b: datfile.NewBuilder(datfile.KindLocation, datfile.WithColumnDict())}
+}
+
+// Add 添加一条 IP 范围和对应的地理位置
+func (b *Builder) Add(start, end netip.Addr, loc iplocsearch.Location) error {
+	return b.b.Add(start, end, loc.String())
+}
+
+// AddPrefix 添加一个网段和对应的地理位置
+func (b *Builder) AddPrefix(prefix netip.Prefix, loc iplocsearch.Location) error {
+	return b.b.AddPrefix(prefix, loc.String())
+}
+
+// AddText 添加一条 IP 范围和竖线分隔的地理位置字符串，格式与 TXT 输入的第 5-15 列相同
+func (b *Builder) AddText(start, end netip.Addr, location string) error {
+	return b.b.Add(start, end, location)
+}
+
+// Len 返回已添加的记录数
+func (b *Builder) Len() int {
+	return b.b.Len()
+}
+
+// WriteTo 生成 .dat 文件内容并写入 w
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	return b.b.WriteTo(w)
+}
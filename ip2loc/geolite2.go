@@ -0,0 +1,211 @@
+package ip2loc
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// geoLocation GeoLite2 Locations 文件中的一个地点，对应内容区的地理位置各列
+type geoLocation struct {
+	continent, country, province, city, district, countryEN, cc string
+}
+
+// ConvertGeoLite2 合并 MaxMind GeoLite2 的 Blocks 文件（GeoLite2-City-Blocks-IPv4.csv、
+// GeoLite2-City-Blocks-IPv6.csv 或对应的 Country 文件）和 Locations 文件（GeoLite2-City-Locations-<lang>.csv），
+// 生成与 Convert 相同格式的 .dat 文件。网段按 geoname_id 关联地点，缺失时依次使用
+// registered_country_geoname_id、represented_country_geoname_id；经纬度取自 Blocks 文件。
+// Locations 不是英文时，同目录下存在 GeoLite2-City-Locations-en.csv 则用于填充 country_en
+func ConvertGeoLite2(blocksFiles []string, locationsFile, outputFile string, opts ...datfile.BuilderOption) (err error) {
+	b := datfile.NewBuilder(datfile.KindLocation, append([]datfile.BuilderOption{datfile.WithColumnDict()}, opts...)...)
+	defer b.Close()
+	err = loadGeoLite2(blocksFiles, locationsFile, b)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	err = generateIPDat(outputFile, b)
+	if err != nil {
+		fmt.Println("生成文件失败:", err)
+		return err
+	}
+	fmt.Printf("生成文件成功: %s\n", outputFile)
+	return
+}
+
+func loadGeoLite2(blocksFiles []string, locationsFile string, b *datfile.Builder) error {
+	locations, locale, err := loadGeoLite2LocationsFile(locationsFile)
+	if err != nil {
+		return err
+	}
+	if locale != "en" {
+		if name := englishLocationsFile(locationsFile); name != "" && name != locationsFile {
+			if _, err := os.Stat(name); err == nil {
+				english, _, err := loadGeoLite2LocationsFile(name)
+				if err != nil {
+					return err
+				}
+				for id, loc := range locations {
+					loc.countryEN = english[id].countryEN
+					locations[id] = loc
+				}
+			}
+		}
+	}
+	if len(blocksFiles) == 0 {
+		return errors.New("缺少 GeoLite2 Blocks 文件")
+	}
+	for _, name := range blocksFiles {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("读取文件失败: %v", err)
+		}
+		err = loadGeoLite2Blocks(f, locations, b)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// englishLocationsFile 返回与 name 同目录的英文 Locations 文件名，name 不是 *-Locations-<lang>.csv 时返回空
+func englishLocationsFile(name string) string {
+	dir, base := filepath.Split(name)
+	i := strings.LastIndex(base, "-Locations-")
+	if i < 0 {
+		return ""
+	}
+	return dir + base[:i] + "-Locations-en.csv"
+}
+
+func loadGeoLite2LocationsFile(name string) (map[string]geoLocation, string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取文件失败: %v", err)
+	}
+	defer f.Close()
+	locations, locale, err := loadGeoLite2Locations(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	return locations, locale, nil
+}
+
+// loadGeoLite2Locations 读取 Locations 文件，返回 geoname_id 到地点的映射和文件的 locale_code
+func loadGeoLite2Locations(r io.Reader) (map[string]geoLocation, string, error) {
+	c := newGeoLite2Reader(r)
+	col, err := readGeoLite2Header(c, "geoname_id", "locale_code", "continent_name", "country_name", "country_iso_code",
+		"subdivision_1_name", "subdivision_2_name", "city_name")
+	if err != nil {
+		return nil, "", err
+	}
+	locations := make(map[string]geoLocation)
+	locale := ""
+	for {
+		fields, err := c.Read()
+		if err == io.EOF {
+			return locations, locale, nil
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("读取文件失败: %w", err)
+		}
+		if locale == "" {
+			locale = col(fields, "locale_code")
+		}
+		loc := geoLocation{
+			continent: col(fields, "continent_name"),
+			country:   col(fields, "country_name"),
+			province:  col(fields, "subdivision_1_name"),
+			city:      col(fields, "city_name"),
+			district:  col(fields, "subdivision_2_name"),
+			cc:        col(fields, "country_iso_code"),
+		}
+		if col(fields, "locale_code") == "en" {
+			loc.countryEN = loc.country
+		}
+		locations[col(fields, "geoname_id")] = loc
+	}
+}
+
+// loadGeoLite2Blocks 读取 Blocks 文件，按 geoname_id 关联地点后添加到 b，无法解析的行打印后跳过
+func loadGeoLite2Blocks(r io.Reader, locations map[string]geoLocation, b *datfile.Builder) error {
+	c := newGeoLite2Reader(r)
+	col, err := readGeoLite2Header(c, "network", "geoname_id")
+	if err != nil {
+		return err
+	}
+	for {
+		fields, err := c.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("读取文件失败: %w", err)
+		}
+		line, _ := c.FieldPos(0)
+		prefix, err := netip.ParsePrefix(col(fields, "network"))
+		if err != nil {
+			fmt.Printf("解析错误: 第 %d 行: 无效的网段: %s\n", line, col(fields, "network"))
+			continue
+		}
+		var loc geoLocation
+		found := false
+		for _, key := range []string{"geoname_id", "registered_country_geoname_id", "represented_country_geoname_id"} {
+			if id := col(fields, key); id != "" {
+				loc, found = locations[id]
+				if !found {
+					fmt.Printf("解析错误: 第 %d 行: 未知的 %s: %s\n", line, key, id)
+				}
+				break
+			}
+		}
+		payload := strings.Join([]string{
+			loc.continent, loc.country, loc.province, loc.city, loc.district, "", "",
+			loc.countryEN, loc.cc, col(fields, "longitude"), col(fields, "latitude"),
+		}, "|")
+		if err = b.AddPrefix(prefix, payload); err != nil {
+			return err
+		}
+	}
+}
+
+func newGeoLite2Reader(r io.Reader) *csv.Reader {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1
+	c.ReuseRecord = true
+	return c
+}
+
+// readGeoLite2Header 读取表头并检查必需的列，返回按列名取值的函数，表头中没有的列取值为空
+func readGeoLite2Header(c *csv.Reader, required ...string) (func(fields []string, name string) string, error) {
+	header, err := c.Read()
+	if err == io.EOF {
+		return nil, errors.New("文件为空")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取表头失败: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, name := range required {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("表头中没有列 %s", name)
+		}
+	}
+	return func(fields []string, name string) string {
+		if i, ok := index[name]; ok && i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}, nil
+}
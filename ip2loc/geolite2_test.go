@@ -0,0 +1,82 @@
+package ip2loc
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/iplocsearch"
+)
+
+const testLocations = "\ufeffgeoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,metro_code,time_zone,is_in_european_union\n" +
+	"2174003,zh-CN,OC,大洋洲,AU,澳大利亚,QLD,昆士兰州,,,布里斯班,,Australia/Brisbane,0\n" +
+	"6252001,zh-CN,NA,北美洲,US,美国,,,,,,,America/Chicago,0\n" +
+	"1814991,zh-CN,AS,亚洲,CN,中国,,,,,,,Asia/Shanghai,0\n"
+
+const testBlocks = "network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius\n" +
+	"1.0.0.0/24,2174003,2077456,,0,0,4000,-27.4766,153.0166,1000\n" +
+	"1.0.1.0/24,,1814991,,0,0,,,,\n" +
+	"8.8.8.0/23,6252001,6252001,,0,0,,37.751,-97.822,1000\n" +
+	"bogus,6252001,,,0,0,,,,\n" +
+	"2001:db8::/32,999,,,0,0,,,,\n"
+
+func TestGeoLite2(t *testing.T) {
+	locations, locale, err := loadGeoLite2Locations(strings.NewReader(testLocations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locale != "zh-CN" || len(locations) != 3 {
+		t.Fatalf("locale = %q，%d 个地点", locale, len(locations))
+	}
+	b := datfile.NewBuilder(datfile.KindLocation, datfile.WithColumnDict())
+	defer b.Close()
+	if err := loadGeoLite2Blocks(strings.NewReader(testBlocks), locations, b); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := iplocsearch.NewBytes(buf.Bytes(), iplocsearch.WithVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.0.0.0", "大洋洲|澳大利亚|昆士兰州|布里斯班|||||AU|153.0166|-27.4766"},
+		{"1.0.0.255", "大洋洲|澳大利亚|昆士兰州|布里斯班|||||AU|153.0166|-27.4766"},
+		{"1.0.1.9", "亚洲|中国|||||||CN||"},
+		{"8.8.9.255", "北美洲|美国|||||||US|-97.822|37.751"},
+		{"2001:db8::1", "||||||||||"},
+	}
+	for _, tt := range tests {
+		got, err := s.Find(tt.ip)
+		if err != nil {
+			t.Errorf("Find(%s): %v", tt.ip, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Find(%s) = %q，期望 %q", tt.ip, got, tt.want)
+		}
+	}
+	if _, err := s.FindAddr(netip.MustParseAddr("8.8.10.0")); err == nil {
+		t.Error("8.8.10.0 不应找到")
+	}
+	loc, _ := s.Lookup("1.0.0.1")
+	if loc.City != "布里斯班" || loc.CountryCode != "AU" || loc.Longitude != 153.0166 {
+		t.Errorf("Lookup = %+v", loc)
+	}
+}
+
+func TestGeoLite2Header(t *testing.T) {
+	if _, _, err := loadGeoLite2Locations(strings.NewReader("geoname_id,country_name\n1,x\n")); err == nil {
+		t.Error("缺少必需的列应返回错误")
+	}
+	if got := englishLocationsFile("/data/GeoLite2-City-Locations-zh-CN.csv"); got != "/data/GeoLite2-City-Locations-en.csv" {
+		t.Errorf("englishLocationsFile = %s", got)
+	}
+}
@@ -1,35 +1,59 @@
 package ip2loc
 
 import (
-	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"net/netip"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/tabular"
 )
 
-type ipData struct {
-	StartIP     uint32
-	EndIP       uint32
-	LocationIdx uint32
-	prefix      uint32
+// schema 地理位置数据的各列。默认格式为 start|end|startNum|endNum 加 11 列地理位置
+var schema = tabular.Schema{
+	Fields: []string{"continent", "country", "province", "city", "district", "isp", "areacode", "country_en", "cc", "lon", "lat"},
+	Aliases: map[string][]string{
+		"continent":  {"continent_name", "continent_zh"},
+		"country":    {"country_name", "country_zh"},
+		"province":   {"region", "region_name", "state", "subdivision", "subdivision_1_name"},
+		"city":       {"city_name"},
+		"district":   {"county", "subdivision_2_name"},
+		"isp":        {"organization", "org", "carrier"},
+		"areacode":   {"area_code", "adcode"},
+		"country_en": {"country_name_en", "country_english"},
+		"cc":         {"country_code", "country_iso_code", "iso_code", "iso2"},
+		"lon":        {"longitude", "lng"},
+		"lat":        {"latitude"},
+	},
+	Default: tabular.Mapping{
+		"start": "1", "end": "2",
+		"continent": "5", "country": "6", "province": "7", "city": "8", "district": "9", "isp": "10",
+		"areacode": "11", "country_en": "12", "cc": "13", "lon": "14", "lat": "15",
+	},
+	Columns: 15,
 }
 
-type locationData struct {
-	Offset uint32
-	Length uint32
-	Text   string
+// Convert 逐行读取输入文件并生成 .dat 文件，各列使用列字典编码，
+// opts 可以用 datfile.WithMaxMemory 限制内存占用
+func Convert(inputFile, outputFile string, opts ...datfile.BuilderOption) error {
+	return ConvertColumns(inputFile, outputFile, nil, opts...)
 }
 
-func Convert(inputFile, outputFile string) (err error) {
-	ipDataList, locations, err := loadIPDataFromFile(inputFile)
+// ConvertColumns 与 Convert 相同，按 columns 指定的列读取起止 IP 和各列地理位置，
+// 列名为 start、end 和 continent|country|province|city|district|isp|areacode|country_en|cc|lon|lat。
+// columns 为空时使用默认格式，第一行为表头时按列名自动映射
+func ConvertColumns(inputFile, outputFile string, columns tabular.Mapping, opts ...datfile.BuilderOption) (err error) {
+	b := datfile.NewBuilder(datfile.KindLocation, append([]datfile.BuilderOption{datfile.WithColumnDict()}, opts...)...)
+	defer b.Close()
+	err = loadIPDataFromFile(inputFile, columns, b)
 	if err != nil {
 		fmt.Println("加载数据失败:", err)
 		return err
 	}
-	err = generateIPDat(outputFile, ipDataList, locations)
+	err = generateIPDat(outputFile, b)
 	if err != nil {
 		fmt.Println("生成文件失败:", err)
 		return err
@@ -38,182 +62,70 @@ func Convert(inputFile, outputFile string) (err error) {
 	return
 }
 
-func loadIPDataFromFile(filename string) ([]ipData, []locationData, error) {
-	data, err := os.ReadFile(filename)
+// loadIPDataFromFile 读取竖线分隔的 TXT、CSV 或 TSV 文件，无法解析的行打印后跳过
+func loadIPDataFromFile(filename string, columns tabular.Mapping, b *datfile.Builder) error {
+	f, err := os.Open(filename)
 	if err != nil {
-		return nil, nil, fmt.Errorf("读取文件失败: %v", err)
+		return fmt.Errorf("读取文件失败: %v", err)
 	}
+	defer f.Close()
 
-	lines := strings.Split(string(data), "\n")
-	var ipDataList []ipData
-	locationMap := make(map[string]uint32)
-	var locations []locationData
-
-	isCSV := strings.HasSuffix(strings.ToLower(filename), ".csv")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+	r, err := tabular.NewReader(f, filename, schema, columns)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var lineErr *tabular.LineError
+		if errors.As(err, &lineErr) {
+			fmt.Printf("解析错误: %v\n", err)
 			continue
 		}
-		// 可选：限制行数测试
-		// if i >= 1000 { break } // 测试小文件时启用
-		var data ipData
-		if isCSV {
-			data, err = parseCSVData(line, locationMap, &locations)
-		} else {
-			data, err = parseIPData(line, locationMap, &locations)
+		if err != nil {
+			return err
+		}
+		startIP, endIP, err := parseIPRange(rec.Start, rec.End)
+		if err == nil {
+			err = datfile.CheckRange(startIP, endIP)
 		}
 		if err != nil {
-			fmt.Printf("解析错误: %v\n", err)
+			fmt.Printf("解析错误: 第 %d 行: %v\n", rec.Line, err)
 			continue
 		}
-		ipDataList = append(ipDataList, data)
-	}
-	return ipDataList, locations, nil
-}
-
-func parseIPData(line string, locationMap map[string]uint32, locations *[]locationData) (ipData, error) {
-	fields := strings.SplitN(line, "|", 15)
-	if len(fields) < 15 {
-		for len(fields) < 15 {
-			fields = append(fields, "")
+		if err = b.Add(startIP, endIP, strings.Join(rec.Fields, "|")); err != nil {
+			return err
 		}
 	}
-
-	startIP := ipToUint32(fields[0])
-	endIP := ipToUint32(fields[1])
-	location := strings.Join(fields[4:15], "|")
-
-	var locIdx uint32
-	if offset, exists := locationMap[location]; exists {
-		locIdx = offset
-	} else {
-		locIdx = uint32(len(*locations))
-		locationMap[location] = locIdx
-		*locations = append(*locations, locationData{Text: location})
-	}
-
-	return ipData{StartIP: startIP, EndIP: endIP, LocationIdx: locIdx, prefix: startIP >> 24}, nil
 }
 
-func parseCSVData(line string, locationMap map[string]uint32, locations *[]locationData) (ipData, error) {
-	fields := strings.Split(line, ",")
-	if len(fields) < 15 {
-		for len(fields) < 15 {
-			fields = append(fields, "")
-		}
+func generateIPDat(filename string, b *datfile.Builder) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
 	}
-
-	for i, field := range fields {
-		fields[i] = strings.Trim(field, `"`)
+	n, err := b.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-
-	startIP := ipToUint32(fields[0])
-	endIP := ipToUint32(fields[1])
-	location := strings.Join(fields[4:15], "|")
-
-	var locIdx uint32
-	if offset, exists := locationMap[location]; exists {
-		locIdx = offset
-	} else {
-		locIdx = uint32(len(*locations))
-		locationMap[location] = locIdx
-		*locations = append(*locations, locationData{Text: location})
+	if err != nil {
+		return err
 	}
-
-	return ipData{StartIP: startIP, EndIP: endIP, LocationIdx: locIdx, prefix: startIP >> 24}, nil
+	fmt.Printf("生成文件大小: %d 字节\n", n)
+	return nil
 }
 
-func generateIPDat(filename string, ipDataList []ipData, locations []locationData) error {
-	sort.Slice(ipDataList, func(i, j int) bool {
-		return ipDataList[i].StartIP < ipDataList[j].StartIP
-	})
-
-	prefixMap := make(map[uint32][]int)
-	for i, ipData := range ipDataList {
-		prefixMap[ipData.prefix] = append(prefixMap[ipData.prefix], i)
-	}
-
-	var buffer bytes.Buffer
-	header := make([]byte, 16)
-	prefixStartOffset := uint32(16)
-	buffer.Write(header)
-
-	// 前缀区：256 * 9字节
-	for prefix := uint32(0); prefix < 256; prefix++ {
-		indices, exists := prefixMap[prefix]
-		var startIndex, endIndex uint32
-		if exists && len(indices) > 0 {
-			startIndex = uint32(indices[0])
-			endIndex = uint32(indices[len(indices)-1])
-		} else {
-			startIndex = 0
-			endIndex = 0
-		}
-		prefixBytes := []byte{byte(prefix)}
-		startBytes := make([]byte, 4)
-		endBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(startBytes, startIndex)
-		binary.LittleEndian.PutUint32(endBytes, endIndex)
-		buffer.Write(prefixBytes)
-		buffer.Write(startBytes)
-		buffer.Write(endBytes)
-	}
-	prefixEndOffset := uint32(buffer.Len()) - 1
-	firstStartIpOffset := prefixEndOffset + 1
-
-	// 索引区：13字节每条（4字节偏移）
-	dataOffset := firstStartIpOffset + uint32(len(ipDataList)*13)
-	for i := range locations {
-		locations[i].Offset = dataOffset
-		locations[i].Length = uint32(len(locations[i].Text))
-		dataOffset += locations[i].Length
-		if locations[i].Length > 255 {
-			fmt.Printf("警告：地理信息长度超255字节：%d\n", locations[i].Length)
-		}
-	}
-
-	for _, ipData := range ipDataList {
-		startIPBytes := make([]byte, 4)
-		endIPBytes := make([]byte, 4)
-		localOffsetBytes := make([]byte, 4)
-		binary.LittleEndian.PutUint32(startIPBytes, ipData.StartIP)
-		binary.LittleEndian.PutUint32(endIPBytes, ipData.EndIP)
-		binary.LittleEndian.PutUint32(localOffsetBytes, locations[ipData.LocationIdx].Offset)
-		localLength := byte(locations[ipData.LocationIdx].Length)
-		if localLength == 0 {
-			localLength = 1
-		}
-		buffer.Write(startIPBytes)
-		buffer.Write(endIPBytes)
-		buffer.Write(localOffsetBytes) // 4字节偏移
-		buffer.WriteByte(localLength)
-	}
-
-	// 内容区
-	for _, loc := range locations {
-		if loc.Text == "" {
-			buffer.WriteString("|")
-		} else {
-			buffer.WriteString(loc.Text)
-		}
+// parseIPRange 解析起止 IP，可以是 IP 文本或十进制数值，版本和顺序由 datfile.CheckRange 校验
+func parseIPRange(start, end string) (netip.Addr, netip.Addr, error) {
+	startIP, err := tabular.ParseIP(start, false)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("无效的起始 IP: %s", start)
 	}
-
-	result := buffer.Bytes()
-	binary.LittleEndian.PutUint32(result[0:4], firstStartIpOffset)
-	binary.LittleEndian.PutUint32(result[8:12], prefixStartOffset)
-	binary.LittleEndian.PutUint32(result[12:16], prefixEndOffset)
-	fmt.Printf("生成文件大小: %d 字节\n", len(result))
-	return os.WriteFile(filename, result, 0644)
-}
-
-func ipToUint32(ip string) uint32 {
-	quads := strings.Split(ip, ".")
-	var result uint32
-	for i, q := range quads {
-		n, _ := strconv.Atoi(q)
-		result |= uint32(n) << (24 - i*8)
+	endIP, err := tabular.ParseIP(end, startIP.Is6())
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("无效的结束 IP: %s", end)
 	}
-	return result
+	return startIP, endIP, nil
 }
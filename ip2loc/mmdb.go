@@ -0,0 +1,206 @@
+package ip2loc
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/iplocsearch"
+	"github.com/billcoding/ip2dat/mmdb"
+	"github.com/billcoding/ip2dat/tabular"
+)
+
+// ExportMMDB 把 Convert 接受的 TXT、CSV 文件或生成的 .dat 文件导出为 MaxMind DB 文件，
+// 各列按 GeoIP2 City 数据库的结构写出，见 MMDBRecord。columns 与 ConvertColumns 相同，.dat 文件忽略
+func ExportMMDB(inputFile, outputFile string, columns tabular.Mapping, opts ...mmdb.WriterOption) (err error) {
+	s, err := loadSearcher(inputFile, columns)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	defer s.Close()
+	w := mmdb.NewWriter(append([]mmdb.WriterOption{
+		mmdb.WithDatabaseType("ip2dat-City"),
+		mmdb.WithLanguages(mmdbLanguage, "en"),
+		mmdb.WithDescription("en", "ip2dat location database"),
+	}, opts...)...)
+	n, err := ExportMMDBTo(w, s)
+	if err == nil {
+		err = writeMMDB(outputFile, w)
+	}
+	if err != nil {
+		fmt.Println("导出文件失败:", err)
+		return err
+	}
+	fmt.Printf("导出文件成功: %s，共 %d 条\n", outputFile, n)
+	return nil
+}
+
+// loadSearcher 打开 .dat 文件，或读取 TXT、CSV 文件后在内存中生成
+func loadSearcher(inputFile string, columns tabular.Mapping) (*iplocsearch.Searcher, error) {
+	if strings.HasSuffix(strings.ToLower(inputFile), ".dat") {
+		return iplocsearch.New(inputFile)
+	}
+	b := datfile.NewBuilder(datfile.KindLocation, datfile.WithColumnDict())
+	defer b.Close()
+	if err := loadIPDataFromFile(inputFile, columns, b); err != nil {
+		return nil, err
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return iplocsearch.NewBytes(data)
+}
+
+func writeMMDB(filename string, w *mmdb.Writer) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	n, err := w.WriteTo(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("生成文件大小: %d 字节\n", n)
+	return nil
+}
+
+// ExportMMDBTo 把 s 中的全部范围添加到 w，返回添加的条数，各列均为空的范围跳过
+func ExportMMDBTo(w *mmdb.Writer, s *iplocsearch.Searcher) (int, error) {
+	n := 0
+	err := s.Ranges(func(start, end netip.Addr, text string) error {
+		record := MMDBRecord(iplocsearch.ParseLocation(text))
+		if len(record) == 0 {
+			return nil
+		}
+		n++
+		return w.Insert(start, end, record)
+	})
+	return n, err
+}
+
+// mmdbLanguage 中文名称在 names 中的语言
+const mmdbLanguage = "zh-CN"
+
+// MMDBRecord 把地理位置转为 GeoIP2 City 数据库的结构：continent、country、subdivisions（省份、区县）、
+// city 的 names 中为中文名称，country.names.en 为国家英文名称，country.iso_code 为国家代码，
+// location 为经纬度；isp 和 area_code 写在顶层。空的字段不写出
+func MMDBRecord(loc iplocsearch.Location) map[string]interface{} {
+	record := make(map[string]interface{})
+	names := func(zh, en string) map[string]interface{} {
+		m := make(map[string]interface{})
+		if zh != "" {
+			m[mmdbLanguage] = zh
+		}
+		if en != "" {
+			m["en"] = en
+		}
+		return m
+	}
+	if loc.Continent != "" {
+		record["continent"] = map[string]interface{}{"names": names(loc.Continent, "")}
+	}
+	country := make(map[string]interface{})
+	if n := names(loc.Country, loc.CountryEN); len(n) > 0 {
+		country["names"] = n
+	}
+	if loc.CountryCode != "" {
+		country["iso_code"] = loc.CountryCode
+	}
+	if len(country) > 0 {
+		record["country"] = country
+	}
+	var subdivisions []interface{}
+	for _, name := range []string{loc.Province, loc.District} {
+		if name != "" {
+			subdivisions = append(subdivisions, map[string]interface{}{"names": names(name, "")})
+		}
+	}
+	if len(subdivisions) > 0 {
+		record["subdivisions"] = subdivisions
+	}
+	if loc.City != "" {
+		record["city"] = map[string]interface{}{"names": names(loc.City, "")}
+	}
+	if loc.Longitude != 0 || loc.Latitude != 0 {
+		record["location"] = map[string]interface{}{"longitude": loc.Longitude, "latitude": loc.Latitude}
+	}
+	if loc.ISP != "" {
+		record["isp"] = loc.ISP
+	}
+	if loc.AreaCode != "" {
+		record["area_code"] = loc.AreaCode
+	}
+	return record
+}
+
+// mmdbPaths ConvertMMDB 默认的 map 路径，与 MMDBRecord 写出的 GeoIP2 City 结构对应
+var mmdbPaths = map[string]string{
+	"continent":  "continent.names.zh-CN",
+	"country":    "country.names.zh-CN",
+	"province":   "subdivisions.0.names.zh-CN",
+	"city":       "city.names.zh-CN",
+	"district":   "subdivisions.1.names.zh-CN",
+	"isp":        "isp",
+	"areacode":   "area_code",
+	"country_en": "country.names.en",
+	"cc":         "country.iso_code",
+	"lon":        "location.longitude",
+	"lat":        "location.latitude",
+}
+
+// ConvertMMDB 读取 MaxMind DB 文件生成 .dat 文件。paths 指定各列取值的 map 路径，以 . 分隔，数组用下标，
+// 如 cc=country.iso_code、city=city.names.zh-CN，未指定的列为空；paths 为空时使用与 MMDBRecord 对应的路径。
+// 相邻且各列相同的网段合并为一个范围，各列均为空的网段跳过
+func ConvertMMDB(inputFile, outputFile string, paths map[string]string, opts ...datfile.BuilderOption) (err error) {
+	b := datfile.NewBuilder(datfile.KindLocation, append([]datfile.BuilderOption{datfile.WithColumnDict()}, opts...)...)
+	defer b.Close()
+	err = loadMMDB(inputFile, paths, b)
+	if err != nil {
+		fmt.Println("加载数据失败:", err)
+		return err
+	}
+	err = generateIPDat(outputFile, b)
+	if err != nil {
+		fmt.Println("生成文件失败:", err)
+		return err
+	}
+	fmt.Printf("生成文件成功: %s\n", outputFile)
+	return
+}
+
+func loadMMDB(filename string, paths map[string]string, b *datfile.Builder) error {
+	if len(paths) == 0 {
+		paths = mmdbPaths
+	}
+	columns, err := mmdb.Columns(paths, schema.Fields)
+	if err != nil {
+		return err
+	}
+	r, err := mmdb.Open(filename)
+	if err != nil {
+		return err
+	}
+	return r.Ranges(func(_ netip.Prefix, value interface{}) string {
+		return mmdbPayload(mmdb.Fields(value, columns))
+	}, b.Add)
+}
+
+// mmdbPayload 拼接各列，列中的 | 替换为空格；各列均为空时返回空字符串
+func mmdbPayload(fields []string) string {
+	empty := true
+	for i, field := range fields {
+		fields[i] = strings.ReplaceAll(field, "|", " ")
+		empty = empty && field == ""
+	}
+	if empty {
+		return ""
+	}
+	return strings.Join(fields, "|")
+}
@@ -0,0 +1,114 @@
+package ip2loc
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/billcoding/ip2dat/datfile"
+	"github.com/billcoding/ip2dat/iplocsearch"
+	"github.com/billcoding/ip2dat/mmdb"
+)
+
+func TestMMDBRecord(t *testing.T) {
+	got := MMDBRecord(iplocsearch.ParseLocation("亚洲|中国|浙江省|杭州市|西湖区|电信|330106|China|CN|120.13|30.27"))
+	want := map[string]interface{}{
+		"continent": map[string]interface{}{"names": map[string]interface{}{"zh-CN": "亚洲"}},
+		"country": map[string]interface{}{
+			"iso_code": "CN",
+			"names":    map[string]interface{}{"zh-CN": "中国", "en": "China"},
+		},
+		"subdivisions": []interface{}{
+			map[string]interface{}{"names": map[string]interface{}{"zh-CN": "浙江省"}},
+			map[string]interface{}{"names": map[string]interface{}{"zh-CN": "西湖区"}},
+		},
+		"city":      map[string]interface{}{"names": map[string]interface{}{"zh-CN": "杭州市"}},
+		"location":  map[string]interface{}{"longitude": 120.13, "latitude": 30.27},
+		"isp":       "电信",
+		"area_code": "330106",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MMDBRecord = %v，期望 %v", got, want)
+	}
+	if got := MMDBRecord(iplocsearch.ParseLocation("||||||||||")); len(got) != 0 {
+		t.Errorf("各列为空时 MMDBRecord = %v，期望为空", got)
+	}
+}
+
+// mmdbRanges 用 paths 读取 MMDB 文件，返回生成的 .dat 中的全部范围
+func mmdbRanges(t *testing.T, filename string, paths map[string]string) []string {
+	t.Helper()
+	b := datfile.NewBuilder(datfile.KindLocation, datfile.WithColumnDict())
+	defer b.Close()
+	if err := loadMMDB(filename, paths, b); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s, err := iplocsearch.NewBytes(buf.Bytes(), iplocsearch.WithVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = s.Ranges(func(start, end netip.Addr, text string) error {
+		got = append(got, start.String()+"-"+end.String()+" "+text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestLoadMMDB(t *testing.T) {
+	w := mmdb.NewWriter()
+	for _, r := range []struct{ start, end, text string }{
+		{"1.0.0.0", "1.0.0.9", "亚洲|中国|浙江省|杭州市|西湖区|电信|330106|China|CN|120.13|30.27"},
+		{"1.0.0.10", "1.0.0.255", "亚洲|中国|浙江省|杭州市|西湖区|电信|330106|China|CN|120.13|30.27"},
+		{"1.0.1.0", "1.0.1.255", "北美洲|美国||||||United States|US||"},
+		{"2001:db8::", "2001:db8::ffff", "||||||||||"},
+	} {
+		record := MMDBRecord(iplocsearch.ParseLocation(r.text))
+		if err := w.Insert(netip.MustParseAddr(r.start), netip.MustParseAddr(r.end), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(t.TempDir(), "test.mmdb")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	got := mmdbRanges(t, filename, nil)
+	want := []string{
+		"1.0.0.0-1.0.0.255 亚洲|中国|浙江省|杭州市|西湖区|电信|330106|China|CN|120.13|30.27",
+		"1.0.1.0-1.0.1.255 北美洲|美国||||||United States|US||",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("默认路径 = %q，期望 %q", got, want)
+	}
+
+	got = mmdbRanges(t, filename, map[string]string{"cc": "country.iso_code", "city": "city.names.zh-CN"})
+	want = []string{
+		"1.0.0.0-1.0.0.255 |||杭州市|||||CN||",
+		"1.0.1.0-1.0.1.255 ||||||||US||",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("指定路径 = %q，期望 %q", got, want)
+	}
+
+	b := datfile.NewBuilder(datfile.KindLocation)
+	defer b.Close()
+	if err := loadMMDB(filename, map[string]string{"zip": "postal.code"}, b); err == nil {
+		t.Error("未知的列名应返回错误")
+	}
+}
@@ -0,0 +1,85 @@
+package ipasnsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ASNRecord 结构化的 ASN 信息，对应 ip2asn 内容区的 ipRange|asn|org
+type ASNRecord struct {
+	Prefix       netip.Prefix // 公告网段
+	ASN          uint32       // 自治系统号，未公告时为 0
+	Organization string       // 组织名称
+	Routed       bool         // 是否有路由公告，源数据中 asn 为 "-" 时为 false
+}
+
+// ParseASNRecord 解析 Get 返回的竖线分隔字符串
+func ParseASNRecord(text string) (ASNRecord, error) {
+	fields := strings.SplitN(text, "|", 3)
+	for len(fields) < 3 {
+		fields = append(fields, "")
+	}
+	var r ASNRecord
+	if fields[0] != "" && fields[0] != "-" {
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return r, fmt.Errorf("无效的网段: %s", fields[0])
+		}
+		r.Prefix = prefix
+	}
+	asn := strings.TrimPrefix(strings.ToUpper(fields[1]), "AS")
+	if asn != "" && asn != "-" {
+		n, err := strconv.ParseUint(asn, 10, 32)
+		if err != nil {
+			return r, fmt.Errorf("无效的 ASN: %s", fields[1])
+		}
+		r.ASN = uint32(n)
+		r.Routed = true
+	}
+	if fields[2] != "-" {
+		r.Organization = fields[2]
+	}
+	return r, nil
+}
+
+// String 返回与 ParseASNRecord 对应的竖线分隔字符串，未公告时 asn 和空的组织名称写为 "-"
+func (r ASNRecord) String() string {
+	prefix, asn, org := "-", "-", r.Organization
+	if r.Prefix.IsValid() {
+		prefix = r.Prefix.String()
+	}
+	if r.Routed {
+		asn = strconv.FormatUint(uint64(r.ASN), 10)
+	} else if org == "" {
+		org = "-"
+	}
+	return prefix + "|" + asn + "|" + org
+}
+
+// Lookup 查询 IP 对应的 ASN 信息，未命中返回 ErrNotFound
+func (s *Searcher) Lookup(ip string) (ASNRecord, error) {
+	text, err := s.Find(ip)
+	if err != nil {
+		return ASNRecord{}, err
+	}
+	return ParseASNRecord(text)
+}
+
+// LookupAddr 与 Lookup 相同，直接接受 netip.Addr
+func (s *Searcher) LookupAddr(addr netip.Addr) (ASNRecord, error) {
+	text, err := s.FindAddr(addr)
+	if err != nil {
+		return ASNRecord{}, err
+	}
+	return ParseASNRecord(text)
+}
+
+// LookupUint32 按大端整数形式的 IPv4 地址查询，如 0x01020304 表示 1.2.3.4
+func (s *Searcher) LookupUint32(ip uint32) (ASNRecord, error) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return s.LookupAddr(netip.AddrFrom4(b))
+}
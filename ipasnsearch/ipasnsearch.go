@@ -2,26 +2,74 @@ package ipasnsearch
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"strconv"
-	"strings"
+	"io"
+	"io/fs"
+	"net/netip"
+	"time"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// Kind 数据集类型
+type Kind = datfile.Kind
+
+const (
+	KindUnknown  = datfile.KindUnknown // 旧版无版本号文件
+	KindLocation = datfile.KindLocation
+	KindASN      = datfile.KindASN
+	KindCustom   = datfile.KindCustom
 )
 
-type ipIndex struct {
-	startIp, endIp, localOffset, localLength uint32
+var (
+	// ErrKindMismatch 文件的数据集类型与 Searcher 不符
+	ErrKindMismatch = datfile.ErrKindMismatch
+	// ErrNotFound 文件中没有覆盖该 IP 的记录
+	ErrNotFound = datfile.ErrNotFound
+	// ErrInvalidIP 查询的字符串不是合法的 IP 地址
+	ErrInvalidIP = datfile.ErrInvalidIP
+	// ErrTooShort 文件长度不足以容纳文件头
+	ErrTooShort = datfile.ErrTooShort
+	// ErrBadFormat 魔数无法识别，不是 .dat 文件
+	ErrBadFormat = datfile.ErrBadFormat
+	// ErrUnsupportedVersion 文件格式版本高于当前支持的版本
+	ErrUnsupportedVersion = datfile.ErrUnsupportedVersion
+	// ErrBadOffset 文件头或索引记录中的偏移超出文件范围
+	ErrBadOffset = datfile.ErrBadOffset
+	// ErrCorruptPrefixTable 前缀区索引颠倒或超出记录数
+	ErrCorruptPrefixTable = datfile.ErrCorruptPrefixTable
+	// ErrChecksum 校验和不匹配
+	ErrChecksum = datfile.ErrChecksum
+	// ErrClosed Searcher 已经关闭
+	ErrClosed = datfile.ErrClosed
+)
+
+// Header 文件头信息，旧版文件 Version 为 0，只有 IPv6 字段有效
+type Header struct {
+	Version     uint16
+	Kind        Kind
+	IPv6        bool
+	Checksum    bool // 文件末尾带 CRC32C 校验和
+	Compressed  bool // 内容分块压缩
+	RecordCount uint32
+	ASNCount    uint32
+	BuildTime   time.Time
 }
-type prefixIndex struct {
-	startIndex, endIndex uint32
+
+// Option New 的可选配置
+type Option = datfile.Option
+
+// WithVerify 打开文件时校验 CRC32C 校验和，不带校验和的旧版文件不做校验
+func WithVerify() Option {
+	return datfile.WithVerify()
+}
+
+// WithBlockCache 设置压缩文件缓存的解压块数，默认 64 块（每块约 8KB），0 表示不缓存
+func WithBlockCache(n int) Option {
+	return datfile.WithBlockCache(n)
 }
 
 type Searcher struct {
-	data      []byte
-	prefixMap map[uint32]prefixIndex
-	firstStartIpOffset,
-	prefixStartOffset,
-	prefixEndOffset,
-	prefixCount uint32
+	r *datfile.Reader
 }
 
 func Search(datFile, ip string) string {
@@ -33,114 +81,104 @@ func Search(datFile, ip string) string {
 	return s.Get(ip)
 }
 
-func New(datFile string) (*Searcher, error) {
-	s := Searcher{}
-	data, err := os.ReadFile(datFile)
+// New 读取并解析 .dat 文件，文件不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)，
+// 文件损坏时返回的错误包装 ErrTooShort、ErrBadFormat、ErrBadOffset 等哨兵错误
+func New(datFile string, opts ...Option) (*Searcher, error) {
+	r, err := datfile.Open(datFile, append(opts, datfile.WithKind(KindASN))...)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	s.data = data
-	s.prefixMap = make(map[uint32]prefixIndex)
-
-	s.firstStartIpOffset = bytesToLong(data[0], data[1], data[2], data[3])
-	s.prefixStartOffset = bytesToLong(data[8], data[9], data[10], data[11])
-	s.prefixEndOffset = bytesToLong(data[12], data[13], data[14], data[15])
-	s.prefixCount = (s.prefixEndOffset-s.prefixStartOffset)/9 + 1
-
-	indexBuffer := s.data[s.prefixStartOffset:(s.prefixEndOffset + 9)]
-	for k := uint32(0); k < s.prefixCount; k++ {
-		i := k * 9
-		prefix := uint32(indexBuffer[i] & 0xFF)
-		pf := prefixIndex{}
-		pf.startIndex = bytesToLong(indexBuffer[i+1], indexBuffer[i+2], indexBuffer[i+3], indexBuffer[i+4])
-		pf.endIndex = bytesToLong(indexBuffer[i+5], indexBuffer[i+6], indexBuffer[i+7], indexBuffer[i+8])
-		s.prefixMap[prefix] = pf
-	}
-	return &s, nil
+	return &Searcher{r: r}, nil
 }
 
-func (s *Searcher) Get(ip string) string {
-	ipS := strings.Split(ip, ".")
-	x, _ := strconv.Atoi(ipS[0])
-	prefix := uint32(x)
-	intIP := ipToLong(ip)
-
-	var high, low uint32
-	if pf, ok := s.prefixMap[prefix]; ok {
-		low = pf.startIndex
-		high = pf.endIndex
-	} else {
-		return ""
+// NewMmap 与 New 相同，但把文件映射到内存而不是读入堆中，多个进程共享页缓存，
+// 启动时无需读取整个文件。使用完毕需调用 Close，Linux 以外的平台等同于 New
+func NewMmap(datFile string, opts ...Option) (*Searcher, error) {
+	r, err := datfile.OpenMmap(datFile, append(opts, datfile.WithKind(KindASN))...)
+	if err != nil {
+		return nil, err
 	}
+	return &Searcher{r: r}, nil
+}
 
-	var myIndex uint32
-	if low == high {
-		myIndex = low
-	} else {
-		myIndex = s.binarySearch(low, high, intIP)
+// NewBytes 解析内存中的 .dat 文件内容，data 在 Searcher 使用期间不能修改
+func NewBytes(data []byte, opts ...Option) (*Searcher, error) {
+	r, err := datfile.NewReader(data, append(opts, datfile.WithKind(KindASN))...)
+	if err != nil {
+		return nil, err
 	}
+	return &Searcher{r: r}, nil
+}
 
-	index := ipIndex{}
-	index.getIndex(myIndex, s)
-
-	if index.startIp <= intIP && index.endIp >= intIP {
-		return index.getLocal(s)
+// NewReaderAt 解析 src 中长度为 size 的 .dat 文件，只有文件头、前缀区等少量数据读入内存，
+// 索引记录和内容在查询时按需读取。src 需支持并发读取，如 *os.File
+func NewReaderAt(src io.ReaderAt, size int64, opts ...Option) (*Searcher, error) {
+	r, err := datfile.NewReaderAt(src, size, append(opts, datfile.WithKind(KindASN))...)
+	if err != nil {
+		return nil, err
 	}
-	return ""
-}
-
-func (s *Searcher) binarySearch(low, high, k uint32) uint32 {
-	var M uint32
-	for low <= high {
-		mid := (low + high) / 2
-		endIpNum := s.getEndIp(mid)
-		if endIpNum >= k {
-			M = mid
-			if mid == 0 {
-				break
-			}
-			high = mid - 1
-		} else {
-			low = mid + 1
-		}
+	return &Searcher{r: r}, nil
+}
+
+// NewFS 从 fsys 中读取并解析 .dat 文件，可用于 //go:embed 嵌入的文件
+func NewFS(fsys fs.FS, name string, opts ...Option) (*Searcher, error) {
+	r, err := datfile.OpenFS(fsys, name, append(opts, datfile.WithKind(KindASN))...)
+	if err != nil {
+		return nil, err
 	}
-	return M
+	return &Searcher{r: r}, nil
 }
 
-func (s *Searcher) getEndIp(left uint32) uint32 {
-	leftOffset := s.firstStartIpOffset + left*13
-	return bytesToLong(s.data[4+leftOffset], s.data[5+leftOffset], s.data[6+leftOffset], s.data[7+leftOffset])
+// Close 解除 NewMmap 建立的内存映射，之后的查询返回 ErrClosed。New 创建的 Searcher 无需关闭
+func (s *Searcher) Close() error {
+	return s.r.Close()
 }
 
-func (p *ipIndex) getIndex(left uint32, ips *Searcher) {
-	leftOffset := ips.firstStartIpOffset + left*13
-	p.startIp = bytesToLong(ips.data[leftOffset], ips.data[1+leftOffset], ips.data[2+leftOffset], ips.data[3+leftOffset])
-	p.endIp = bytesToLong(ips.data[4+leftOffset], ips.data[5+leftOffset], ips.data[6+leftOffset], ips.data[7+leftOffset])
-	p.localOffset = bytesToLong(ips.data[8+leftOffset], ips.data[9+leftOffset], ips.data[10+leftOffset], ips.data[11+leftOffset])
-	p.localLength = uint32(ips.data[12+leftOffset])
+// Header 返回文件头信息
+func (s *Searcher) Header() Header {
+	return headerFrom(s.r.Header())
 }
 
-func (p *ipIndex) getLocal(ips *Searcher) string {
-	bytes := ips.data[p.localOffset : p.localOffset+p.localLength]
-	return string(bytes)
+func headerFrom(h datfile.Header) Header {
+	return Header{
+		Version:     h.Version,
+		Kind:        h.Kind,
+		IPv6:        h.IPv6,
+		Checksum:    h.Checksum,
+		Compressed:  h.Compressed,
+		RecordCount: h.RecordCount,
+		ASNCount:    h.PayloadCount,
+		BuildTime:   h.BuildTime,
+	}
+}
+
+// Verify 检查文件的完整性和结构，返回发现的全部问题
+func (s *Searcher) Verify() []error {
+	return s.r.Verify()
 }
 
-func ipToLong(ip string) uint32 {
-	quads := strings.Split(ip, ".")
-	var result uint32
-	for i, q := range quads {
-		n, _ := strconv.Atoi(q)
-		result |= uint32(n) << (24 - i*8)
+func (s *Searcher) Get(ip string) string {
+	asn, _ := s.Find(ip)
+	return asn
+}
+
+// Find 查询 IP 对应的原始内容，IP 不合法（包括首尾空白、缺段、越界）返回 ErrInvalidIP，
+// 未命中返回 ErrNotFound
+func (s *Searcher) Find(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIP, ip)
 	}
-	return result
+	return s.FindAddr(addr)
 }
 
-func bytesToLong(a, b, c, d byte) uint32 {
-	return uint32(a) | (uint32(b) << 8) | (uint32(c) << 16) | (uint32(d) << 24)
+// FindAddr 与 Find 相同，直接接受 netip.Addr
+func (s *Searcher) FindAddr(addr netip.Addr) (string, error) {
+	return s.r.Find(addr)
 }
 
-func main() {
-	// 示例使用
-	result := Search("asn.dat", "8.8.8.8")
-	fmt.Println(result)
+// Ranges 按 IP 顺序对每个范围调用 fn，text 与 Get 返回的内容相同，
+// fn 返回错误时停止遍历并返回该错误
+func (s *Searcher) Ranges(fn func(start, end netip.Addr, text string) error) error {
+	return s.r.Ranges(fn)
 }
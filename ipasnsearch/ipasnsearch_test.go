@@ -0,0 +1,176 @@
+package ipasnsearch
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+func TestParseASNRecord(t *testing.T) {
+	tests := []struct {
+		text string
+		want ASNRecord
+	}{
+		{"1.0.0.0/24|13335|CloudFlare Inc.", ASNRecord{netip.MustParsePrefix("1.0.0.0/24"), 13335, "CloudFlare Inc.", true}},
+		{"1.0.0.0/24|AS13335|Org|With|Pipes", ASNRecord{netip.MustParsePrefix("1.0.0.0/24"), 13335, "Org|With|Pipes", true}},
+		{"2001:db8::/32|as64496|", ASNRecord{netip.MustParsePrefix("2001:db8::/32"), 64496, "", true}},
+		// 未公告的范围
+		{"0.0.0.0/8|-|-", ASNRecord{Prefix: netip.MustParsePrefix("0.0.0.0/8")}},
+		{"-|-|-", ASNRecord{}},
+		{"1.0.0.0/24", ASNRecord{Prefix: netip.MustParsePrefix("1.0.0.0/24")}},
+		{"", ASNRecord{}},
+	}
+	for _, tt := range tests {
+		got, err := ParseASNRecord(tt.text)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseASNRecord(%q) = %+v, %v，期望 %+v", tt.text, got, err, tt.want)
+		}
+	}
+
+	for _, text := range []string{"1.0.0/24|13335|x", "1.0.0.0/33|13335|x", "1.0.0.0/24|abc|x", "1.0.0.0/24|4294967296|x"} {
+		if _, err := ParseASNRecord(text); err == nil {
+			t.Errorf("ParseASNRecord(%q) 期望返回错误", text)
+		}
+	}
+
+	for _, text := range []string{"1.0.0.0/24|13335|CloudFlare Inc.", "0.0.0.0/8|-|-", "1.0.0.0/24|-|Reserved"} {
+		r, _ := ParseASNRecord(text)
+		if got := r.String(); got != text {
+			t.Errorf("String() = %q，期望 %q", got, text)
+		}
+	}
+}
+
+func newTestSearcher(t *testing.T) *Searcher {
+	t.Helper()
+	b := datfile.NewBuilder(datfile.KindASN)
+	defer b.Close()
+	for _, r := range []struct{ prefix, text string }{
+		{"0.0.0.0/8", "0.0.0.0/8|-|-"},
+		{"1.0.0.0/24", "1.0.0.0/24|13335|CloudFlare Inc."},
+		{"1.0.1.0/24", "1.0.1.0/24|bad|x"},
+		{"2001:db8::/32", "2001:db8::/32|64496|Doc"},
+	} {
+		if err := b.AddPrefix(netip.MustParsePrefix(r.prefix), r.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewBytes(data, WithVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLookup(t *testing.T) {
+	s := newTestSearcher(t)
+	cloudflare := ASNRecord{netip.MustParsePrefix("1.0.0.0/24"), 13335, "CloudFlare Inc.", true}
+	for _, ip := range []string{"1.0.0.0", "1.0.0.255", "::ffff:1.0.0.1"} {
+		if got, err := s.Lookup(ip); err != nil || got != cloudflare {
+			t.Errorf("Lookup(%s) = %+v, %v", ip, got, err)
+		}
+	}
+	if got, err := s.LookupUint32(0x01000080); err != nil || got != cloudflare {
+		t.Errorf("LookupUint32 = %+v, %v", got, err)
+	}
+	if got, err := s.Lookup("0.1.2.3"); err != nil || got.Routed || got.ASN != 0 || got.Organization != "" {
+		t.Errorf("未公告的范围 Lookup = %+v, %v", got, err)
+	}
+	if got, err := s.LookupAddr(netip.MustParseAddr("2001:db8::1")); err != nil || got.ASN != 64496 {
+		t.Errorf("LookupAddr(2001:db8::1) = %+v, %v", got, err)
+	}
+	if _, err := s.Lookup("1.0.1.1"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("无法解析的内容 Lookup = %v，期望解析错误", err)
+	}
+
+	for _, ip := range []string{"1.0.2.0", "2001:db9::"} {
+		if _, err := s.Lookup(ip); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup(%s) = %v，期望 ErrNotFound", ip, err)
+		}
+	}
+	if _, err := s.LookupUint32(0x01000200); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LookupUint32 = %v，期望 ErrNotFound", err)
+	}
+
+	for _, ip := range []string{"1.2.3", " 8.8.8.8", "8.8.8.8\n", "300.1.1.1", "abc", "", "1.0.0.1/24"} {
+		if _, err := s.Find(ip); !errors.Is(err, ErrInvalidIP) {
+			t.Errorf("Find(%q) = %v，期望 ErrInvalidIP", ip, err)
+		}
+		if _, err := s.Lookup(ip); !errors.Is(err, ErrInvalidIP) {
+			t.Errorf("Lookup(%q) = %v，期望 ErrInvalidIP", ip, err)
+		}
+		if got := s.Get(ip); got != "" {
+			t.Errorf("Get(%q) = %q，期望为空", ip, got)
+		}
+	}
+}
+
+func writeWatchFile(t *testing.T, name, text string) {
+	t.Helper()
+	b := datfile.NewBuilder(datfile.KindASN)
+	defer b.Close()
+	if err := b.AddPrefix(netip.MustParsePrefix("1.0.0.0/24"), text); err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name+".tmp", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.dat")
+	writeWatchFile(t, name, "1.0.0.0/24|13335|A")
+	var mu sync.Mutex
+	var headers []Header
+	w, err := Watch(name, WithInterval(2*time.Millisecond), OnReload(func(h Header) {
+		mu.Lock()
+		headers = append(headers, h)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// 回调为 nil 时不调用回调，也不能在后台 goroutine 中 panic
+	quiet, err := Watch(name, WithInterval(2*time.Millisecond), OnReload(nil), OnReloadError(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+
+	writeWatchFile(t, name, "1.0.0.0/24|13336|B")
+	reloaded := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(headers) > 0 && w.Get("1.0.0.1") == "1.0.0.0/24|13336|B" && quiet.Get("1.0.0.1") == "1.0.0.0/24|13336|B"
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reloaded() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待重新加载超时: %q, %q", w.Get("1.0.0.1"), quiet.Get("1.0.0.1"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) == 0 || headers[0].Kind != KindASN || headers[0].RecordCount != 1 {
+		t.Errorf("OnReload 收到 %+v", headers)
+	}
+}
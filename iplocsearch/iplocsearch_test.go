@@ -0,0 +1,175 @@
+package iplocsearch
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		text string
+		want Location
+	}{
+		{
+			"亚洲|中国|浙江省|杭州市|西湖区|电信|330106|China|CN|120.13|30.27",
+			Location{"亚洲", "中国", "浙江省", "杭州市", "西湖区", "电信", "330106", "China", "CN", 120.13, 30.27},
+		},
+		{"亚洲|中国", Location{Continent: "亚洲", Country: "中国"}},
+		{"", Location{}},
+		{"||||||||||", Location{}},
+		{"||||||||| -95.713 | 37.09 ", Location{Longitude: -95.713, Latitude: 37.09}},
+		{"|||||||||abc|1.5", Location{Latitude: 1.5}},
+		// 多出的列并入纬度列，纬度无法解析为 0
+		{"|||||||||1.5|2.5|extra", Location{Longitude: 1.5}},
+	}
+	for _, tt := range tests {
+		if got := ParseLocation(tt.text); got != tt.want {
+			t.Errorf("ParseLocation(%q) = %+v，期望 %+v", tt.text, got, tt.want)
+		}
+	}
+
+	text := "大洋洲|澳大利亚|昆士兰州|布里斯班||Cloudflare||Australia|AU|153.025|-27.47"
+	if got := ParseLocation(text).String(); got != text {
+		t.Errorf("String() = %q，期望 %q", got, text)
+	}
+	if got := (Location{Country: "中国"}).String(); got != "|中国|||||||||" {
+		t.Errorf("经纬度为 0 时 String() = %q", got)
+	}
+}
+
+const testLocation = "大洋洲|澳大利亚|昆士兰州|布里斯班||Cloudflare||Australia|AU|153.025|-27.47"
+
+func newTestSearcher(t *testing.T, opts ...datfile.BuilderOption) *Searcher {
+	t.Helper()
+	b := datfile.NewBuilder(datfile.KindLocation, opts...)
+	defer b.Close()
+	if err := b.AddPrefix(netip.MustParsePrefix("1.0.0.0/24"), testLocation); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddPrefix(netip.MustParsePrefix("2001:db8::/32"), "亚洲|中国"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewBytes(data, WithVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestLookup(t *testing.T) {
+	for _, dict := range []bool{false, true} {
+		var opts []datfile.BuilderOption
+		if dict {
+			opts = append(opts, datfile.WithColumnDict())
+		}
+		s := newTestSearcher(t, opts...)
+		want := ParseLocation(testLocation)
+
+		for _, ip := range []string{"1.0.0.0", "1.0.0.255", "::ffff:1.0.0.1"} {
+			if got, err := s.Lookup(ip); err != nil || got != want {
+				t.Errorf("dict=%v: Lookup(%s) = %+v, %v", dict, ip, got, err)
+			}
+		}
+		if got, err := s.LookupUint32(0x01000080); err != nil || got != want {
+			t.Errorf("dict=%v: LookupUint32 = %+v, %v", dict, got, err)
+		}
+		if got, err := s.Lookup("2001:db8::1"); err != nil || got != (Location{Continent: "亚洲", Country: "中国"}) {
+			t.Errorf("dict=%v: Lookup(2001:db8::1) = %+v, %v", dict, got, err)
+		}
+		if got, err := s.Find("1.0.0.1"); err != nil || got != testLocation {
+			t.Errorf("dict=%v: Find = %q, %v", dict, got, err)
+		}
+
+		for _, ip := range []string{"1.0.1.0", "0.255.255.255", "2001:db9::"} {
+			if _, err := s.Lookup(ip); !errors.Is(err, ErrNotFound) {
+				t.Errorf("dict=%v: Lookup(%s) = %v，期望 ErrNotFound", dict, ip, err)
+			}
+		}
+		if _, err := s.LookupUint32(0x01000100); !errors.Is(err, ErrNotFound) {
+			t.Errorf("dict=%v: LookupUint32 = %v，期望 ErrNotFound", dict, err)
+		}
+
+		for _, ip := range []string{"1.2.3", " 1.0.0.1", "1.0.0.1 ", "300.1.1.1", "abc", "", "1.0.0.1/24"} {
+			if _, err := s.Find(ip); !errors.Is(err, ErrInvalidIP) {
+				t.Errorf("dict=%v: Find(%q) = %v，期望 ErrInvalidIP", dict, ip, err)
+			}
+			if _, err := s.Lookup(ip); !errors.Is(err, ErrInvalidIP) {
+				t.Errorf("dict=%v: Lookup(%q) = %v，期望 ErrInvalidIP", dict, ip, err)
+			}
+			if got := s.Get(ip); got != "" {
+				t.Errorf("dict=%v: Get(%q) = %q，期望为空", dict, ip, got)
+			}
+		}
+	}
+}
+
+func writeWatchFile(t *testing.T, name, text string) {
+	t.Helper()
+	b := datfile.NewBuilder(datfile.KindLocation)
+	defer b.Close()
+	if err := b.AddPrefix(netip.MustParsePrefix("1.0.0.0/24"), text); err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name+".tmp", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.dat")
+	writeWatchFile(t, name, "亚洲|中国")
+	var mu sync.Mutex
+	var headers []Header
+	w, err := Watch(name, WithInterval(2*time.Millisecond), OnReload(func(h Header) {
+		mu.Lock()
+		headers = append(headers, h)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// 回调为 nil 时不调用回调，也不能在后台 goroutine 中 panic
+	quiet, err := Watch(name, WithInterval(2*time.Millisecond), OnReload(nil), OnReloadError(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+
+	writeWatchFile(t, name, "亚洲|日本")
+	reloaded := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(headers) > 0 && w.Get("1.0.0.1") == "亚洲|日本" && quiet.Get("1.0.0.1") == "亚洲|日本"
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reloaded() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待重新加载超时: %q, %q", w.Get("1.0.0.1"), quiet.Get("1.0.0.1"))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) == 0 || headers[0].Kind != KindLocation || headers[0].RecordCount != 1 {
+		t.Errorf("OnReload 收到 %+v", headers)
+	}
+}
@@ -0,0 +1,96 @@
+package iplocsearch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Location 结构化的地理位置信息，字段顺序与 ip2loc 内容区一致：
+// continent|country|province|city|district|isp|areacode|country_en|cc|lon|lat
+type Location struct {
+	Continent   string  // 大洲
+	Country     string  // 国家
+	Province    string  // 省份
+	City        string  // 城市
+	District    string  // 区县
+	ISP         string  // 运营商
+	AreaCode    string  // 行政区划代码
+	CountryEN   string  // 国家英文名称
+	CountryCode string  // ISO 3166-1 两位国家代码
+	Longitude   float64 // 经度
+	Latitude    float64 // 纬度
+}
+
+// locationFields 内容区中每条地理位置的字段数
+const locationFields = 11
+
+// ParseLocation 解析 Get 返回的竖线分隔字符串，缺失的字段留空，
+// 无法解析的经纬度为 0
+func ParseLocation(text string) Location {
+	return locationFromFields(strings.SplitN(text, "|", locationFields))
+}
+
+// locationFromFields 由各列生成 Location，多出的列并入纬度列，与 ParseLocation 一致
+func locationFromFields(fields []string) Location {
+	if len(fields) > locationFields {
+		fields = append(fields[:locationFields-1:locationFields-1], strings.Join(fields[locationFields-1:], "|"))
+	}
+	for len(fields) < locationFields {
+		fields = append(fields, "")
+	}
+	loc := Location{
+		Continent:   fields[0],
+		Country:     fields[1],
+		Province:    fields[2],
+		City:        fields[3],
+		District:    fields[4],
+		ISP:         fields[5],
+		AreaCode:    fields[6],
+		CountryEN:   fields[7],
+		CountryCode: fields[8],
+	}
+	loc.Longitude, _ = strconv.ParseFloat(strings.TrimSpace(fields[9]), 64)
+	loc.Latitude, _ = strconv.ParseFloat(strings.TrimSpace(fields[10]), 64)
+	return loc
+}
+
+// String 返回与 ParseLocation 对应的竖线分隔字符串，经纬度均为 0 时留空
+func (l Location) String() string {
+	var lon, lat string
+	if l.Longitude != 0 || l.Latitude != 0 {
+		lon = strconv.FormatFloat(l.Longitude, 'f', -1, 64)
+		lat = strconv.FormatFloat(l.Latitude, 'f', -1, 64)
+	}
+	return strings.Join([]string{
+		l.Continent, l.Country, l.Province, l.City, l.District, l.ISP,
+		l.AreaCode, l.CountryEN, l.CountryCode, lon, lat,
+	}, "|")
+}
+
+// Lookup 查询 IP 对应的地理位置，未命中返回 ErrNotFound
+func (s *Searcher) Lookup(ip string) (Location, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return Location{}, fmt.Errorf("%w: %q", ErrInvalidIP, ip)
+	}
+	return s.LookupAddr(addr)
+}
+
+// LookupAddr 与 Lookup 相同，直接接受 netip.Addr。列字典编码的文件直接从字典取出各字段
+func (s *Searcher) LookupAddr(addr netip.Addr) (Location, error) {
+	fields, err := s.r.FindColumns(addr)
+	if err != nil {
+		return Location{}, err
+	}
+	return locationFromFields(fields), nil
+}
+
+// LookupUint32 按大端整数形式的 IPv4 地址查询，如 0x01020304 表示 1.2.3.4
+func (s *Searcher) LookupUint32(ip uint32) (Location, error) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return s.LookupAddr(netip.AddrFrom4(b))
+}
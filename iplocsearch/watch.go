@@ -0,0 +1,108 @@
+package iplocsearch
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// WatchOption Watch 的可选配置
+type WatchOption = datfile.WatchOption
+
+// WithInterval 设置检查文件变化的间隔，默认 30 秒
+func WithInterval(d time.Duration) WatchOption {
+	return datfile.WithInterval(d)
+}
+
+// WithSearcherOptions 设置每次加载文件时使用的配置，如 WithBlockCache
+func WithSearcherOptions(opts ...Option) WatchOption {
+	return datfile.WithOptions(opts...)
+}
+
+// OnReload 设置文件重新加载成功后的回调，在后台 goroutine 中调用，fn 为 nil 时不调用回调
+func OnReload(fn func(Header)) WatchOption {
+	if fn == nil {
+		return datfile.OnReload(nil)
+	}
+	return datfile.OnReload(func(h datfile.Header) {
+		fn(headerFrom(h))
+	})
+}
+
+// OnReloadError 设置文件变化后加载或校验失败的回调，在后台 goroutine 中调用，
+// 此时继续使用之前加载的文件
+func OnReloadError(fn func(error)) WatchOption {
+	return datfile.OnReloadError(fn)
+}
+
+// Watcher 自动重新加载的 Searcher：定期检查文件，文件变化且校验通过后原子替换，
+// 并发的查询总是使用某一版完整加载的文件
+type Watcher struct {
+	w *datfile.Watcher
+}
+
+// Watch 加载 .dat 文件并开始在后台检查文件变化，首次加载失败时返回错误。
+// 使用完毕需调用 Close 停止检查
+func Watch(datFile string, opts ...WatchOption) (*Watcher, error) {
+	w, err := datfile.NewWatcher(datFile, append([]WatchOption{datfile.WithOptions(datfile.WithKind(KindLocation))}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{w: w}, nil
+}
+
+// Searcher 返回当前加载的文件对应的 Searcher，多次查询需要使用同一版文件时使用
+func (w *Watcher) Searcher() *Searcher {
+	return &Searcher{r: w.w.Reader()}
+}
+
+// Reload 立即重新加载文件，加载或校验失败时继续使用之前的文件并返回错误
+func (w *Watcher) Reload() error {
+	return w.w.Reload()
+}
+
+// Close 停止检查文件变化，之后仍可查询最后加载的文件
+func (w *Watcher) Close() error {
+	return w.w.Close()
+}
+
+// Header 返回当前文件的文件头信息
+func (w *Watcher) Header() Header {
+	return w.Searcher().Header()
+}
+
+// Get 见 Searcher.Get
+func (w *Watcher) Get(ip string) string {
+	return w.Searcher().Get(ip)
+}
+
+// Find 见 Searcher.Find
+func (w *Watcher) Find(ip string) (string, error) {
+	return w.Searcher().Find(ip)
+}
+
+// FindAddr 见 Searcher.FindAddr
+func (w *Watcher) FindAddr(addr netip.Addr) (string, error) {
+	return w.Searcher().FindAddr(addr)
+}
+
+// Ranges 见 Searcher.Ranges
+func (w *Watcher) Ranges(fn func(start, end netip.Addr, text string) error) error {
+	return w.Searcher().Ranges(fn)
+}
+
+// Lookup 见 Searcher.Lookup
+func (w *Watcher) Lookup(ip string) (Location, error) {
+	return w.Searcher().Lookup(ip)
+}
+
+// LookupAddr 见 Searcher.LookupAddr
+func (w *Watcher) LookupAddr(addr netip.Addr) (Location, error) {
+	return w.Searcher().LookupAddr(addr)
+}
+
+// LookupUint32 见 Searcher.LookupUint32
+func (w *Watcher) LookupUint32(ip uint32) (Location, error) {
+	return w.Searcher().LookupUint32(ip)
+}
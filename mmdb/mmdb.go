@@ -0,0 +1,180 @@
+// Package mmdb 读写 MaxMind DB（.mmdb）文件：二叉搜索树、数据区和元数据，
+// 格式见 https://maxmind.github.io/MaxMind-DB/
+//
+// 数据区的值对应以下 Go 类型：string、[]byte、float64、float32、bool、uint16、uint32、
+// uint64、int32、map[string]interface{} 和 []interface{}。
+package mmdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// metadataMarker 元数据开始的标记，元数据位于文件末尾
+const metadataMarker = "\xAB\xCD\xEFMaxMind.com"
+
+// dataSectionSeparator 搜索树与数据区之间的 16 个零字节
+const dataSectionSeparator = 16
+
+// 数据区的值类型
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxSize 控制字节能表示的最大长度
+const maxSize = 65821 + 1<<24 - 1
+
+// appendControl 写出值的控制字节：高 3 位为类型（扩展类型另起一个字节），低 5 位为长度，
+// 长度不小于 29 时用后续 1 到 3 个字节表示
+func appendControl(buf []byte, typ int, size int) ([]byte, error) {
+	if size < 0 || size > maxSize {
+		return buf, fmt.Errorf("数据长度 %d 超出范围", size)
+	}
+	var first byte
+	if typ <= typeMap {
+		first = byte(typ) << 5
+	}
+	var ext []byte
+	switch {
+	case size < 29:
+		first |= byte(size)
+	case size < 285:
+		first |= 29
+		ext = []byte{byte(size - 29)}
+	case size < 65821:
+		first |= 30
+		s := size - 285
+		ext = []byte{byte(s >> 8), byte(s)}
+	default:
+		first |= 31
+		s := size - 65821
+		ext = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+	buf = append(buf, first)
+	if typ > typeMap {
+		buf = append(buf, byte(typ-typeMap))
+	}
+	return append(buf, ext...), nil
+}
+
+// appendPointer 写出指向数据区偏移 offset 的指针：控制字节中第 4、5 位为指针长度，
+// 低 3 位和后续 1 到 3 个字节为偏移，较长的两种分别减去 2048 和 526336；最长的一种为 4 个字节的偏移
+func appendPointer(buf []byte, offset uint32) []byte {
+	const control = typePointer << 5
+	switch {
+	case offset < 1<<11:
+		return append(buf, control|byte(offset>>8), byte(offset))
+	case offset < 1<<19+2048:
+		o := offset - 2048
+		return append(buf, control|1<<3|byte(o>>16), byte(o>>8), byte(o))
+	case offset < 1<<27+526336:
+		o := offset - 526336
+		return append(buf, control|2<<3|byte(o>>24), byte(o>>16), byte(o>>8), byte(o))
+	}
+	return append(buf, control|3<<3, byte(offset>>24), byte(offset>>16), byte(offset>>8), byte(offset))
+}
+
+// appendUint 写出无符号整数，只保留去掉前导零后的字节
+func appendUint(buf []byte, typ int, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	n := 0
+	for n < 8 && b[n] == 0 {
+		n++
+	}
+	buf, _ = appendControl(buf, typ, 8-n)
+	return append(buf, b[n:]...)
+}
+
+// appendValue 按数据区格式写出 v，map 的键按字典序写出
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		if buf, err = appendControl(buf, typeString, len(v)); err != nil {
+			return buf, err
+		}
+		return append(buf, v...), nil
+	case []byte:
+		if buf, err = appendControl(buf, typeBytes, len(v)); err != nil {
+			return buf, err
+		}
+		return append(buf, v...), nil
+	case float64:
+		buf, _ = appendControl(buf, typeDouble, 8)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+		return append(buf, b[:]...), nil
+	case float32:
+		buf, _ = appendControl(buf, typeFloat, 4)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], math.Float32bits(v))
+		return append(buf, b[:]...), nil
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return appendControl(buf, typeBool, size)
+	case uint16:
+		return appendUint(buf, typeUint16, uint64(v)), nil
+	case uint32:
+		return appendUint(buf, typeUint32, uint64(v)), nil
+	case uint64:
+		return appendUint(buf, typeUint64, v), nil
+	case int32:
+		if v >= 0 {
+			return appendUint(buf, typeInt32, uint64(v)), nil
+		}
+		buf, _ = appendControl(buf, typeInt32, 4)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(v))
+		return append(buf, b[:]...), nil
+	case map[string]interface{}:
+		if buf, err = appendControl(buf, typeMap, len(v)); err != nil {
+			return buf, err
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if buf, err = appendValue(buf, k); err != nil {
+				return buf, err
+			}
+			if buf, err = appendValue(buf, v[k]); err != nil {
+				return buf, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return buf, nil
+	case []interface{}:
+		if buf, err = appendControl(buf, typeArray, len(v)); err != nil {
+			return buf, err
+		}
+		for _, item := range v {
+			if buf, err = appendValue(buf, item); err != nil {
+				return buf, err
+			}
+		}
+		return buf, nil
+	}
+	return buf, fmt.Errorf("不支持的数据类型: %T", v)
+}
@@ -0,0 +1,526 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+var (
+	// ErrInvalidDatabase 文件不是有效的 MaxMind DB 文件或已损坏
+	ErrInvalidDatabase = errors.New("无效的 MaxMind DB 文件")
+	// ErrNotFound 数据库中没有覆盖该 IP 的网段
+	ErrNotFound = errors.New("未找到 IP 记录")
+)
+
+// maxMetadataSize 在文件末尾查找元数据标记的范围
+const maxMetadataSize = 128 << 10
+
+// maxDecodeDepth 解码时 map、数组和指针的最大嵌套层数，防止损坏的文件造成死循环
+const maxDecodeDepth = 64
+
+// Metadata 数据库的元数据
+type Metadata struct {
+	NodeCount    uint32            // 搜索树的节点数
+	RecordSize   uint16            // 搜索树记录的位数：24、28 或 32
+	IPVersion    uint16            // 4 或 6
+	DatabaseType string            // 数据库类型，如 GeoIP2-City
+	Languages    []string          // names 中包含的语言
+	Description  map[string]string // 各语言的描述
+	BuildEpoch   uint64            // 生成时间，Unix 秒
+}
+
+// Reader 读取内存中的 MaxMind DB 文件，可以并发使用
+type Reader struct {
+	data      []byte
+	meta      Metadata
+	nodeBytes int
+	dataStart int   // 数据区在文件中的偏移
+	ipv4Start int64 // IPv6 数据库中 ::/96 对应的节点，没有时为 -1
+}
+
+// Open 读取并解析 MaxMind DB 文件
+func Open(name string) (*Reader, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	return NewReader(data)
+}
+
+// NewReader 解析内存中的 MaxMind DB 文件内容，data 在 Reader 使用期间不能修改。
+// 文件损坏时返回的错误包装 ErrInvalidDatabase
+func NewReader(data []byte) (*Reader, error) {
+	tail := data
+	if len(tail) > maxMetadataSize {
+		tail = tail[len(tail)-maxMetadataSize:]
+	}
+	i := bytes.LastIndex(tail, []byte(metadataMarker))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: 没有元数据", ErrInvalidDatabase)
+	}
+	metaStart := len(data) - len(tail) + i + len(metadataMarker)
+	d := decoder{buf: data[metaStart:]}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("元数据: %w", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: 元数据不是 map", ErrInvalidDatabase)
+	}
+	meta := Metadata{
+		NodeCount:    uint32(metadataUint(m["node_count"])),
+		RecordSize:   uint16(metadataUint(m["record_size"])),
+		IPVersion:    uint16(metadataUint(m["ip_version"])),
+		DatabaseType: metadataString(m["database_type"]),
+		BuildEpoch:   metadataUint(m["build_epoch"]),
+		Description:  make(map[string]string),
+	}
+	if langs, ok := m["languages"].([]interface{}); ok {
+		for _, lang := range langs {
+			meta.Languages = append(meta.Languages, metadataString(lang))
+		}
+	}
+	if desc, ok := m["description"].(map[string]interface{}); ok {
+		for lang, text := range desc {
+			meta.Description[lang] = metadataString(text)
+		}
+	}
+	if major := metadataUint(m["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("%w: 不支持的格式版本 %d", ErrInvalidDatabase, major)
+	}
+	switch meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: 不支持的记录长度 %d 位", ErrInvalidDatabase, meta.RecordSize)
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: 不支持的 IP 版本 %d", ErrInvalidDatabase, meta.IPVersion)
+	}
+
+	r := &Reader{data: data, meta: meta, nodeBytes: int(meta.RecordSize) / 4, ipv4Start: -1}
+	treeSize := int(meta.NodeCount) * r.nodeBytes
+	r.dataStart = treeSize + dataSectionSeparator
+	if r.dataStart > len(data)-len(tail)+i {
+		return nil, fmt.Errorf("%w: 搜索树超出文件长度", ErrInvalidDatabase)
+	}
+	if meta.IPVersion == 6 {
+		n := int64(0)
+		for depth := 0; depth < 96 && n < int64(meta.NodeCount); depth++ {
+			n = r.record(n, 0)
+		}
+		if n < int64(meta.NodeCount) {
+			r.ipv4Start = n
+		}
+	}
+	return r, nil
+}
+
+func metadataUint(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint16:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	return 0
+}
+
+func metadataString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// Metadata 返回数据库的元数据
+func (r *Reader) Metadata() Metadata {
+	return r.meta
+}
+
+// record 返回节点 n 的左（bit 为 0）或右子记录
+func (r *Reader) record(n int64, bit byte) int64 {
+	b := r.data[int(n)*r.nodeBytes : int(n+1)*r.nodeBytes]
+	switch r.meta.RecordSize {
+	case 24:
+		b = b[int(bit)*3:]
+		return int64(b[0])<<16 | int64(b[1])<<8 | int64(b[2])
+	case 28:
+		if bit == 0 {
+			return int64(b[3]>>4)<<24 | int64(b[0])<<16 | int64(b[1])<<8 | int64(b[2])
+		}
+		return int64(b[3]&0x0F)<<24 | int64(b[4])<<16 | int64(b[5])<<8 | int64(b[6])
+	}
+	return int64(binary.BigEndian.Uint32(b[int(bit)*4:]))
+}
+
+// resolve 解码数据记录 value 指向的数据
+func (r *Reader) resolve(value int64) (interface{}, error) {
+	offset := value - int64(r.meta.NodeCount) - dataSectionSeparator
+	if offset < 0 || r.dataStart+int(offset) >= len(r.data) {
+		return nil, fmt.Errorf("%w: 数据偏移 %d 越界", ErrInvalidDatabase, offset)
+	}
+	d := decoder{buf: r.data[r.dataStart:]}
+	v, _, err := d.decode(int(offset), 0)
+	return v, err
+}
+
+// Lookup 查询 addr 所在网段的数据，IPv4 数据库不能查询 IPv6 地址
+func (r *Reader) Lookup(addr netip.Addr) (interface{}, error) {
+	if !addr.IsValid() {
+		return nil, fmt.Errorf("无效的 IP: %s", addr)
+	}
+	addr = addr.Unmap()
+	key, bits := addr.As16(), 128
+	if r.meta.IPVersion == 4 {
+		if !addr.Is4() {
+			return nil, fmt.Errorf("IPv4 数据库不支持查询 IPv6 地址: %s", addr)
+		}
+		v4 := addr.As4()
+		key, bits = [16]byte{}, 32
+		copy(key[:], v4[:])
+	} else if addr.Is4() {
+		v4 := addr.As4()
+		key = [16]byte{}
+		copy(key[12:], v4[:])
+	}
+	n, count := int64(0), int64(r.meta.NodeCount)
+	for i := 0; i < bits && n < count; i++ {
+		n = r.record(n, key[i/8]>>(7-i%8)&1)
+	}
+	switch {
+	case n == count:
+		return nil, ErrNotFound
+	case n < count:
+		return nil, fmt.Errorf("%w: 搜索树深度超过 %d", ErrInvalidDatabase, bits)
+	}
+	return r.resolve(n)
+}
+
+// Networks 按 IP 顺序遍历数据库中有数据的全部网段。IPv6 数据库中 ::/96 下的网段以 IPv4 网段给出，
+// 指向同一 IPv4 子树的别名网段（如 ::ffff:0:0/96）跳过。相同的数据只解码一次，fn 不能修改 value
+func (r *Reader) Networks(fn func(prefix netip.Prefix, value interface{}) error) error {
+	bits := 128
+	if r.meta.IPVersion == 4 {
+		bits = 32
+	}
+	count := int64(r.meta.NodeCount)
+	cache := make(map[int64]interface{})
+	var walk func(n int64, key [16]byte, depth int) error
+	walk = func(n int64, key [16]byte, depth int) error {
+		for bit := byte(0); bit < 2; bit++ {
+			key[depth/8] |= bit << (7 - depth%8)
+			child := r.record(n, bit)
+			switch {
+			case child == count:
+			case child < count:
+				if depth+1 >= bits {
+					return fmt.Errorf("%w: 搜索树深度超过 %d", ErrInvalidDatabase, bits)
+				}
+				if child == r.ipv4Start && !(depth+1 == 96 && isZero(key[:12])) {
+					continue
+				}
+				if err := walk(child, key, depth+1); err != nil {
+					return err
+				}
+			default:
+				value, ok := cache[child]
+				if !ok {
+					var err error
+					if value, err = r.resolve(child); err != nil {
+						return err
+					}
+					cache[child] = value
+				}
+				if err := fn(r.prefix(key, depth+1), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(0, [16]byte{}, 0)
+}
+
+// Ranges 按 IP 顺序遍历数据库，text 把网段和数据转为文本，相邻且文本相同的网段合并为一个范围后调用 fn。
+// text 返回空字符串时跳过该网段
+func (r *Reader) Ranges(text func(prefix netip.Prefix, value interface{}) string, fn func(start, end netip.Addr, text string) error) error {
+	var start, end netip.Addr
+	var current string
+	flush := func() error {
+		if !start.IsValid() {
+			return nil
+		}
+		err := fn(start, end, current)
+		start = netip.Addr{}
+		return err
+	}
+	err := r.Networks(func(prefix netip.Prefix, value interface{}) error {
+		t := text(prefix, value)
+		first, last := prefix.Addr(), datfile.LastAddr(prefix)
+		if start.IsValid() && t == current && end.Next() == first {
+			end = last
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if t != "" {
+			start, end, current = first, last, t
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// prefix 返回搜索树中长度为 bits 的路径 key 对应的网段
+func (r *Reader) prefix(key [16]byte, bits int) netip.Prefix {
+	if r.meta.IPVersion == 4 {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{key[0], key[1], key[2], key[3]}), bits)
+	}
+	if bits >= 96 && isZero(key[:12]) {
+		return netip.PrefixFrom(netip.AddrFrom4([4]byte{key[12], key[13], key[14], key[15]}), bits-96)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(key), bits)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decoder 解码数据区，指针为相对 buf 起始的偏移
+type decoder struct {
+	buf []byte
+}
+
+// decode 解码 offset 处的值，返回值和之后的偏移
+func (d *decoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w: 数据嵌套过深", ErrInvalidDatabase)
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		v, _, err := d.decode(size, depth+1)
+		return v, offset, err
+	}
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{})
+		for i := 0; i < size; i++ {
+			var k, v interface{}
+			if k, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map 的键不是字符串", ErrInvalidDatabase)
+			}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+		}
+		return m, offset, nil
+	case typeArray:
+		var a []interface{}
+		for i := 0; i < size; i++ {
+			var v interface{}
+			if v, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, fmt.Errorf("%w: 布尔值长度 %d", ErrInvalidDatabase, size)
+		}
+		return size == 1, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, fmt.Errorf("%w: 数据超出数据区", ErrInvalidDatabase)
+	}
+	b := d.buf[offset : offset+size]
+	end := offset + size
+	switch typ {
+	case typeString:
+		return string(b), end, nil
+	case typeBytes:
+		return append([]byte(nil), b...), end, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: double 长度 %d", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), end, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: float 长度 %d", ErrInvalidDatabase, size)
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), end, nil
+	case typeUint16, typeUint32, typeInt32, typeUint64:
+		limit := map[int]int{typeUint16: 2, typeUint32: 4, typeInt32: 4, typeUint64: 8}[typ]
+		if size > limit {
+			return nil, 0, fmt.Errorf("%w: 整数长度 %d", ErrInvalidDatabase, size)
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		switch typ {
+		case typeUint16:
+			return uint16(n), end, nil
+		case typeUint32:
+			return uint32(n), end, nil
+		case typeInt32:
+			return int32(uint32(n)), end, nil
+		}
+		return n, end, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("%w: 整数长度 %d", ErrInvalidDatabase, size)
+		}
+		return new(big.Int).SetBytes(b), end, nil
+	}
+	return nil, 0, fmt.Errorf("%w: 不支持的数据类型 %d", ErrInvalidDatabase, typ)
+}
+
+// control 解析 offset 处的控制字节，返回类型、长度（指针为指向的偏移）和数据开始的偏移
+func (d *decoder) control(offset int) (typ, size, next int, err error) {
+	short := fmt.Errorf("%w: 数据超出数据区", ErrInvalidDatabase)
+	if offset >= len(d.buf) {
+		return 0, 0, 0, short
+	}
+	c := d.buf[offset]
+	offset++
+	typ = int(c >> 5)
+	if typ == typePointer {
+		n := int(c>>3) & 3
+		if offset+n+1 > len(d.buf) {
+			return 0, 0, 0, short
+		}
+		p := 0
+		if n < 3 {
+			p = int(c & 7)
+		}
+		for _, b := range d.buf[offset : offset+n+1] {
+			p = p<<8 | int(b)
+		}
+		p += []int{0, 2048, 526336, 0}[n]
+		return typ, p, offset + n + 1, nil
+	}
+	if typ == typeExtended {
+		if offset >= len(d.buf) {
+			return 0, 0, 0, short
+		}
+		typ = int(d.buf[offset]) + typeMap
+		offset++
+		if typ <= typeMap {
+			return 0, 0, 0, fmt.Errorf("%w: 无效的扩展类型 %d", ErrInvalidDatabase, typ)
+		}
+	}
+	size = int(c & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return 0, 0, 0, short
+		}
+		ext := 0
+		for _, b := range d.buf[offset : offset+n] {
+			ext = ext<<8 | int(b)
+		}
+		size = []int{29, 285, 65821}[n-1] + ext
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+// Path 按以 . 分隔的路径取出 value 中的值，map 按键、数组按从 0 开始的下标，
+// 如 country.iso_code、city.names.zh-CN、subdivisions.0.names.en
+func Path(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Columns 按 fields 的顺序返回 paths 中各列的 map 路径，paths 中没有的列为空，
+// 不在 fields 中的列名返回错误
+func Columns(paths map[string]string, fields []string) ([]string, error) {
+	columns := make([]string, len(fields))
+	for name, path := range paths {
+		i := 0
+		for i < len(fields) && fields[i] != name {
+			i++
+		}
+		if i == len(fields) {
+			return nil, fmt.Errorf("未知的列名: %s，可用的列名: %s", name, strings.Join(fields, ", "))
+		}
+		columns[i] = path
+	}
+	return columns, nil
+}
+
+// Fields 按 paths 依次取出 value 中的值并用 FormatValue 转为文本，路径为空或不存在时为空
+func Fields(value interface{}, paths []string) []string {
+	fields := make([]string, len(paths))
+	for i, path := range paths {
+		if path == "" {
+			continue
+		}
+		if v, ok := Path(value, path); ok {
+			fields[i] = FormatValue(v)
+		}
+	}
+	return fields
+}
+
+// FormatValue 把数据区的值转为文本：浮点数为最短的十进制表示，nil 为空，其它类型按 fmt.Sprint 格式
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
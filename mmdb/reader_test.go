@@ -0,0 +1,232 @@
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testValues = []interface{}{
+	map[string]interface{}{
+		"country":      map[string]interface{}{"iso_code": "CN", "names": map[string]interface{}{"zh-CN": "中国", "en": "China"}},
+		"location":     map[string]interface{}{"latitude": 30.27, "longitude": 120.13},
+		"subdivisions": []interface{}{map[string]interface{}{"names": map[string]interface{}{"zh-CN": "浙江省"}}},
+	},
+	map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "CN", "names": map[string]interface{}{"zh-CN": "中国", "en": "China"}},
+		"long":    strings.Repeat("长", 200),
+	},
+	map[string]interface{}{
+		"autonomous_system_number": uint32(13335),
+		"flags":                    []interface{}{true, false, int32(-7), uint16(65535), uint64(1) << 40, float32(0.5), []byte{0, 1}},
+	},
+	"plain",
+}
+
+func buildTestDB(t *testing.T, ipv6 bool, recordSize int, alias bool) []byte {
+	t.Helper()
+	w := NewWriter(WithRecordSize(recordSize), WithDatabaseType("Test-DB"), WithLanguages("zh-CN", "en"), WithDescription("en", "test"))
+	type insert struct {
+		start, end string
+		value      interface{}
+	}
+	inserts := []insert{
+		{"1.0.0.0", "1.0.0.255", testValues[0]},
+		{"1.0.1.0", "1.0.1.9", testValues[1]},
+		{"8.8.8.8", "8.8.8.8", testValues[2]},
+	}
+	if ipv6 {
+		inserts = append(inserts, insert{"2001:db8::", "2001:db8::ffff", testValues[3]})
+	}
+	for _, in := range inserts {
+		if err := w.Insert(netip.MustParseAddr(in.start), netip.MustParseAddr(in.end), in.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if alias {
+		// 与 MaxMind 的 IPv6 数据库相同，::ffff:0:0/96 指向 IPv4 子树
+		ipv4 := int64(0)
+		for i := 0; i < 96; i++ {
+			ipv4 = w.nodes[ipv4][0]
+		}
+		n := int64(0)
+		key := netip.MustParseAddr("::ffff:0:0").As16()
+		for i := 0; i < 95; i++ {
+			child := w.nodes[n][key[i/8]>>(7-i%8)&1]
+			if child <= 0 {
+				w.nodes = append(w.nodes, node{child, child})
+				child = int64(len(w.nodes) - 1)
+				w.nodes[n][key[i/8]>>(7-i%8)&1] = child
+			}
+			n = child
+		}
+		w.nodes[n][1] = ipv4
+	}
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	for _, ipv6 := range []bool{false, true} {
+		for _, recordSize := range []int{24, 28, 32} {
+			r, err := NewReader(buildTestDB(t, ipv6, recordSize, ipv6))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			meta := r.Metadata()
+			wantVersion := uint16(4)
+			if ipv6 {
+				wantVersion = 6
+			}
+			if meta.IPVersion != wantVersion || int(meta.RecordSize) != recordSize || meta.DatabaseType != "Test-DB" ||
+				!reflect.DeepEqual(meta.Languages, []string{"zh-CN", "en"}) || meta.Description["en"] != "test" || meta.BuildEpoch == 0 {
+				t.Errorf("Metadata = %+v", meta)
+			}
+
+			type lookup struct {
+				ip   string
+				want interface{} // nil 表示没有数据
+			}
+			lookups := []lookup{
+				{"1.0.0.0", testValues[0]},
+				{"1.0.0.255", testValues[0]},
+				{"::ffff:1.0.1.9", testValues[1]},
+				{"8.8.8.8", testValues[2]},
+				{"1.0.1.10", nil},
+				{"8.8.8.9", nil},
+			}
+			if ipv6 {
+				lookups = append(lookups, lookup{"2001:db8::1", testValues[3]}, lookup{"2001:db8::1:0", nil})
+			}
+			for _, l := range lookups {
+				got, err := r.Lookup(netip.MustParseAddr(l.ip))
+				if l.want == nil {
+					if !errors.Is(err, ErrNotFound) {
+						t.Errorf("Lookup(%s) = %v, %v，期望 ErrNotFound", l.ip, got, err)
+					}
+					continue
+				}
+				if err != nil || !reflect.DeepEqual(got, l.want) {
+					t.Errorf("Lookup(%s) = %v, %v，期望 %v", l.ip, got, err, l.want)
+				}
+			}
+			if !ipv6 {
+				if _, err := r.Lookup(netip.MustParseAddr("2001:db8::1")); err == nil {
+					t.Error("IPv4 数据库查询 IPv6 地址应返回错误")
+				}
+			}
+
+			var networks []string
+			err = r.Networks(func(prefix netip.Prefix, value interface{}) error {
+				networks = append(networks, prefix.String())
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Networks: %v", err)
+			}
+			want := []string{"1.0.0.0/24", "1.0.1.0/29", "1.0.1.8/31", "8.8.8.8/32"}
+			if ipv6 {
+				want = append(want, "2001:db8::/112")
+			}
+			if !reflect.DeepEqual(networks, want) {
+				t.Errorf("ipv6=%v record=%d: Networks = %v，期望 %v", ipv6, recordSize, networks, want)
+			}
+		}
+	}
+}
+
+// TestRanges 相邻且文本相同的网段合并，文本为空的网段跳过
+func TestRanges(t *testing.T) {
+	r, err := NewReader(buildTestDB(t, true, 24, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err = r.Ranges(func(prefix netip.Prefix, value interface{}) string {
+		return strings.Join(Fields(value, []string{"country.iso_code", "", "country.names.en"}), "|")
+	}, func(start, end netip.Addr, text string) error {
+		got = append(got, start.String()+"-"+end.String()+" "+text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.0.0.0-1.0.1.9 CN||China", "8.8.8.8-8.8.8.8 ||", "2001:db8::-2001:db8::ffff ||"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ranges = %q，期望 %q", got, want)
+	}
+
+	got = nil
+	err = r.Ranges(func(prefix netip.Prefix, value interface{}) string {
+		v, _ := Path(value, "country.iso_code")
+		return FormatValue(v)
+	}, func(start, end netip.Addr, text string) error {
+		got = append(got, start.String()+"-"+end.String()+" "+text)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0.0-1.0.1.9 CN"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Ranges = %q，期望 %q", got, want)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	data := buildTestDB(t, true, 24, false)
+	if _, err := NewReader(data[:len(data)-60]); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("截断的文件 NewReader = %v，期望 ErrInvalidDatabase", err)
+	}
+	if _, err := NewReader([]byte("not a database")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("NewReader = %v，期望 ErrInvalidDatabase", err)
+	}
+
+	// 数据区中的指针指向自身
+	d := decoder{buf: []byte{0x20, 0x00}}
+	if _, _, err := d.decode(0, 0); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("循环指针 decode = %v，期望 ErrInvalidDatabase", err)
+	}
+	d = decoder{buf: []byte{0x5d}}
+	if _, _, err := d.decode(0, 0); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("截断的长度 decode = %v，期望 ErrInvalidDatabase", err)
+	}
+	d = decoder{buf: []byte{0x03, 0x03, 0x01, 0x02, 0x03}}
+	if v, _, err := d.decode(0, 0); err != nil || v.(*big.Int).Int64() != 0x010203 {
+		t.Errorf("uint128 decode = %v, %v", v, err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"country.iso_code", "CN", true},
+		{"country.names.zh-CN", "中国", true},
+		{"subdivisions.0.names.zh-CN", "浙江省", true},
+		{"subdivisions.1.names.zh-CN", "", false},
+		{"location.longitude", "120.13", true},
+		{"city.names.en", "", false},
+		{"country.iso_code.x", "", false},
+	}
+	for _, tt := range tests {
+		v, ok := Path(testValues[0], tt.path)
+		if ok != tt.ok || FormatValue(v) != tt.want {
+			t.Errorf("Path(%s) = %v, %v，期望 %q, %v", tt.path, v, ok, tt.want, tt.ok)
+		}
+	}
+	if got := FormatValue(float32(0.1)); got != "0.1" {
+		t.Errorf("FormatValue(float32(0.1)) = %s", got)
+	}
+	if got := FormatValue(uint32(13335)); got != "13335" {
+		t.Errorf("FormatValue(uint32) = %s", got)
+	}
+}
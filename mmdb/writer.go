@@ -0,0 +1,335 @@
+package mmdb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+
+	"github.com/billcoding/ip2dat/datfile"
+)
+
+// node 搜索树的节点，两个子节点分别对应下一位为 0 和 1：
+// 0 表示没有数据，正数为节点下标，负数 -(offset+1) 为数据区偏移
+type node [2]int64
+
+// WriterOption Writer 的可选配置
+type WriterOption func(*Writer)
+
+// WithDatabaseType 设置元数据中的 database_type，默认为 "ip2dat"
+func WithDatabaseType(t string) WriterOption {
+	return func(w *Writer) {
+		w.databaseType = t
+	}
+}
+
+// WithDescription 设置元数据中 lang 语言的描述
+func WithDescription(lang, text string) WriterOption {
+	return func(w *Writer) {
+		w.description[lang] = text
+	}
+}
+
+// WithLanguages 设置元数据中的 languages，即数据中 names 包含的语言
+func WithLanguages(langs ...string) WriterOption {
+	return func(w *Writer) {
+		w.languages = langs
+	}
+}
+
+// WithRecordSize 设置搜索树记录的位数：24、28 或 32，默认按节点数和数据区大小选择最小的一种
+func WithRecordSize(bits int) WriterOption {
+	return func(w *Writer) {
+		w.recordSize = bits
+	}
+}
+
+// Writer 在内存中构建搜索树和数据区，WriteTo 写出 MaxMind DB 文件。
+// 只有 IPv4 数据时写出 ip_version 为 4 的数据库，否则为 6，IPv4 地址位于 ::a.b.c.d
+type Writer struct {
+	databaseType string
+	description  map[string]string
+	languages    []string
+	recordSize   int
+
+	nodes   []node            // nodes[0] 为根节点，未被引用的节点不写出
+	data    []byte            // 数据区
+	offsets map[string]uint32 // 编码后的值到数据区偏移，相同的值只写一次
+	ipv6    bool              // 添加过 IPv6 网段
+}
+
+// NewWriter 创建 Writer
+func NewWriter(opts ...WriterOption) *Writer {
+	w := &Writer{
+		databaseType: "ip2dat",
+		description:  make(map[string]string),
+		nodes:        make([]node, 1),
+		offsets:      make(map[string]uint32),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Insert 添加一个 IP 范围，范围拆分为最少的网段。已有的网段与之重叠时，重叠部分以后添加的为准
+func (w *Writer) Insert(start, end netip.Addr, value interface{}) error {
+	if err := datfile.CheckRange(start, end); err != nil {
+		return err
+	}
+	start, end = start.Unmap().WithZone(""), end.Unmap().WithZone("")
+	ref, err := w.store(value)
+	if err != nil {
+		return err
+	}
+	for {
+		prefix := netip.PrefixFrom(start, start.BitLen())
+		for bits := 0; bits < start.BitLen(); bits++ {
+			p := netip.PrefixFrom(start, bits)
+			if p.Masked().Addr() == start && !end.Less(datfile.LastAddr(p)) {
+				prefix = p
+				break
+			}
+		}
+		w.insert(prefix, ref)
+		last := datfile.LastAddr(prefix)
+		if last == end {
+			return nil
+		}
+		start = last.Next()
+	}
+}
+
+// InsertPrefix 添加一个网段
+func (w *Writer) InsertPrefix(prefix netip.Prefix, value interface{}) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("无效的网段: %s", prefix)
+	}
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	ref, err := w.store(value)
+	if err != nil {
+		return err
+	}
+	w.insert(prefix, ref)
+	return nil
+}
+
+// store 把值写入数据区，返回对应的子节点引用
+func (w *Writer) store(value interface{}) (int64, error) {
+	buf, err := appendValue(nil, value)
+	if err != nil {
+		return 0, err
+	}
+	offset, ok := w.offsets[string(buf)]
+	if !ok {
+		offset = uint32(len(w.data))
+		w.appendShared(value, buf)
+	}
+	return -int64(offset) - 1, nil
+}
+
+// appendShared 把 v 写到数据区末尾，encoded 为 v 不含指针的编码。map 和数组中已写入数据区的键和值
+// 改为指向原有位置的指针（指针更短时），新出现的键和值记录位置供之后引用
+func (w *Writer) appendShared(v interface{}, encoded []byte) {
+	if offset, ok := w.offsets[string(encoded)]; ok {
+		if p := appendPointer(nil, offset); len(p) < len(encoded) {
+			w.data = append(w.data, p...)
+			return
+		}
+	} else {
+		w.offsets[string(encoded)] = uint32(len(w.data))
+	}
+	// v 已由 appendValue 编码成功，以下不会再返回错误
+	switch v := v.(type) {
+	case map[string]interface{}:
+		w.data, _ = appendControl(w.data, typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key, _ := appendValue(nil, k)
+			w.appendShared(k, key)
+			value, _ := appendValue(nil, v[k])
+			w.appendShared(v[k], value)
+		}
+	case []interface{}:
+		w.data, _ = appendControl(w.data, typeArray, len(v))
+		for _, item := range v {
+			value, _ := appendValue(nil, item)
+			w.appendShared(item, value)
+		}
+	default:
+		w.data = append(w.data, encoded...)
+	}
+}
+
+// insert 沿网段的各位向下查找，途中遇到数据时拆分为两个子节点，最后一位指向数据
+func (w *Writer) insert(prefix netip.Prefix, ref int64) {
+	key := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		key = [16]byte{}
+		v4 := prefix.Addr().As4()
+		copy(key[12:], v4[:])
+		bits += 96
+	} else {
+		w.ipv6 = true
+	}
+	if bits == 0 {
+		w.nodes[0] = node{ref, ref}
+		return
+	}
+	cur := int64(0)
+	for i := 0; ; i++ {
+		bit := key[i/8] >> (7 - i%8) & 1
+		if i == bits-1 {
+			w.nodes[cur][bit] = ref
+			return
+		}
+		next := w.nodes[cur][bit]
+		if next <= 0 {
+			w.nodes = append(w.nodes, node{next, next})
+			next = int64(len(w.nodes) - 1)
+			w.nodes[cur][bit] = next
+		}
+		cur = next
+	}
+}
+
+// WriteTo 依次写出搜索树、16 个零字节、数据区和元数据
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	root, ipVersion := int64(0), uint16(6)
+	if !w.ipv6 {
+		// 只有 IPv4 数据时以 ::/96 对应的节点为根
+		ipVersion = 4
+		for i := 0; i < 96; i++ {
+			if root = w.nodes[root][0]; root <= 0 {
+				break
+			}
+		}
+		if root <= 0 {
+			// 没有数据或 0.0.0.0/0 对应一个数据，根节点的两个子节点相同
+			w.nodes = append(w.nodes, node{root, root})
+			root = int64(len(w.nodes) - 1)
+		}
+	}
+
+	// 按广度优先给可达的节点编号
+	numbers := make(map[int64]uint32)
+	order := []int64{root}
+	numbers[root] = 0
+	for i := 0; i < len(order); i++ {
+		for _, child := range w.nodes[order[i]] {
+			if _, ok := numbers[child]; child > 0 && !ok {
+				numbers[child] = uint32(len(order))
+				order = append(order, child)
+			}
+		}
+	}
+	nodeCount := uint64(len(order))
+	maxValue := nodeCount + dataSectionSeparator + uint64(len(w.data))
+	recordSize := w.recordSize
+	if recordSize == 0 {
+		recordSize = 24
+		for recordSize < 32 && maxValue >= 1<<uint(recordSize) {
+			recordSize += 4
+		}
+	}
+	switch recordSize {
+	case 24, 28, 32:
+	default:
+		return 0, fmt.Errorf("不支持的记录长度: %d 位", recordSize)
+	}
+	if maxValue >= 1<<uint(recordSize) {
+		return 0, fmt.Errorf("%d 位的记录无法表示 %d 个节点和 %d 字节数据", recordSize, nodeCount, len(w.data))
+	}
+
+	cw := &countingWriter{w: out}
+	bw := bufio.NewWriterSize(cw, 1<<16)
+	value := func(child int64) uint64 {
+		switch {
+		case child > 0:
+			return uint64(numbers[child])
+		case child < 0:
+			return nodeCount + dataSectionSeparator + uint64(-child-1)
+		}
+		return nodeCount
+	}
+	buf := make([]byte, recordSize/4)
+	for _, n := range order {
+		left, right := value(w.nodes[n][0]), value(w.nodes[n][1])
+		switch recordSize {
+		case 24:
+			putUint24(buf[0:], left)
+			putUint24(buf[3:], right)
+		case 28:
+			putUint24(buf[0:], left)
+			buf[3] = byte(left>>24)<<4 | byte(right>>24)&0x0F
+			putUint24(buf[4:], right)
+		case 32:
+			putUint24(buf[1:], left)
+			buf[0] = byte(left >> 24)
+			putUint24(buf[5:], right)
+			buf[4] = byte(right >> 24)
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return cw.n, err
+		}
+	}
+	if _, err := bw.Write(make([]byte, dataSectionSeparator)); err != nil {
+		return cw.n, err
+	}
+	if _, err := bw.Write(w.data); err != nil {
+		return cw.n, err
+	}
+
+	languages := make([]interface{}, len(w.languages))
+	for i, lang := range w.languages {
+		languages[i] = lang
+	}
+	description := make(map[string]interface{}, len(w.description))
+	for lang, text := range w.description {
+		description[lang] = text
+	}
+	metadata, err := appendValue([]byte(metadataMarker), map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               w.databaseType,
+		"description":                 description,
+		"ip_version":                  ipVersion,
+		"languages":                   languages,
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return cw.n, err
+	}
+	if _, err := bw.Write(metadata); err != nil {
+		return cw.n, err
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+func putUint24(b []byte, v uint64) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,249 @@
+package mmdb
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestAppendValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  []byte
+	}{
+		{"abc", []byte{0x43, 'a', 'b', 'c'}},
+		{"", []byte{0x40}},
+		{strings.Repeat("x", 29), append([]byte{0x5d, 0x00}, strings.Repeat("x", 29)...)},
+		{strings.Repeat("x", 300), append([]byte{0x5e, 0x00, 0x0f}, strings.Repeat("x", 300)...)},
+		{strings.Repeat("x", 65821), append([]byte{0x5f, 0x00, 0x00, 0x00}, strings.Repeat("x", 65821)...)},
+		{uint16(0), []byte{0xa0}},
+		{uint32(500), []byte{0xc2, 0x01, 0xf4}},
+		{uint64(1), []byte{0x01, 0x02, 0x01}},
+		{int32(1), []byte{0x01, 0x01, 0x01}},
+		{int32(-1), []byte{0x04, 0x01, 0xff, 0xff, 0xff, 0xff}},
+		{true, []byte{0x01, 0x07}},
+		{false, []byte{0x00, 0x07}},
+		{float64(1.5), []byte{0x68, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{float32(1.5), []byte{0x04, 0x08, 0x3f, 0xc0, 0, 0}},
+		{[]byte{1, 2}, []byte{0x82, 1, 2}},
+		{[]interface{}{"a"}, []byte{0x01, 0x04, 0x41, 'a'}},
+		{map[string]interface{}{"zh": "x", "en": uint16(1)}, []byte{0xe2, 0x42, 'e', 'n', 0xa1, 0x01, 0x42, 'z', 'h', 0x41, 'x'}},
+	}
+	for _, tt := range tests {
+		got, err := appendValue(nil, tt.value)
+		if err != nil {
+			t.Errorf("appendValue(%.20v): %v", tt.value, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("appendValue(%.20v) = % .12x，期望 % .12x", tt.value, got, tt.want)
+		}
+	}
+	if _, err := appendValue(nil, 1); err == nil {
+		t.Error("int 应返回不支持的类型")
+	}
+}
+
+func TestAppendPointer(t *testing.T) {
+	tests := []struct {
+		offset uint32
+		want   []byte
+	}{
+		{0, []byte{0x20, 0x00}},
+		{2047, []byte{0x27, 0xff}},
+		{2048, []byte{0x28, 0x00, 0x00}},
+		{526335, []byte{0x2f, 0xff, 0xff}},
+		{526336, []byte{0x30, 0x00, 0x00, 0x00}},
+		{134744063, []byte{0x37, 0xff, 0xff, 0xff}},
+		{134744064, []byte{0x38, 0x08, 0x08, 0x08, 0x00}},
+	}
+	for _, tt := range tests {
+		if got := appendPointer(nil, tt.offset); !bytes.Equal(got, tt.want) {
+			t.Errorf("appendPointer(%d) = % x，期望 % x", tt.offset, got, tt.want)
+		}
+	}
+}
+
+// TestStoreShared 已写入数据区的键和值改为指针，短于指针的值直接写出
+func TestStoreShared(t *testing.T) {
+	w := NewWriter()
+	country := map[string]interface{}{"iso_code": "CN"}
+	first, err := w.store(map[string]interface{}{"country": country, "x": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.store(map[string]interface{}{"country": country, "x": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := w.store(map[string]interface{}{"country": country, "x": "1"})
+	if first != -1 || second != -27 || again != first {
+		t.Errorf("引用 = %d, %d, %d，期望 -1, -27, -1", first, second, again)
+	}
+	want := []byte{0xe2, 0x20, 0x01, 0x20, 0x09, 0x41, 'x', 0x41, '2'}
+	if got := w.data[26:]; !bytes.Equal(got, want) {
+		t.Errorf("第二个值 = % x，期望 % x", got, want)
+	}
+}
+
+// lookupOffset 按搜索树查找 addr，返回数据区偏移，没有数据时返回 -1
+func lookupOffset(t *testing.T, db []byte, dataLen, recordSize int, ipv6 bool, addr netip.Addr) int {
+	t.Helper()
+	metaStart := bytes.LastIndex(db, []byte(metadataMarker))
+	nodeBytes := recordSize / 4
+	nodeCount := (metaStart - dataLen - dataSectionSeparator) / nodeBytes
+	key := addr.As16()
+	bits := 128
+	if !ipv6 {
+		v4 := addr.As4()
+		key, bits = [16]byte{}, 32
+		copy(key[:], v4[:])
+	} else if addr.Is4() {
+		key = [16]byte{}
+		v4 := addr.As4()
+		copy(key[12:], v4[:])
+	}
+	n := 0
+	for i := 0; i < bits; i++ {
+		b := db[n*nodeBytes : (n+1)*nodeBytes]
+		var left, right int
+		switch recordSize {
+		case 24:
+			left = int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+			right = int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		case 28:
+			left = int(b[3]>>4)<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+			right = int(b[3]&0x0F)<<24 | int(b[4])<<16 | int(b[5])<<8 | int(b[6])
+		case 32:
+			left = int(b[0])<<24 | int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+			right = int(b[4])<<24 | int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+		}
+		next := left
+		if key[i/8]>>(7-i%8)&1 == 1 {
+			next = right
+		}
+		switch {
+		case next == nodeCount:
+			return -1
+		case next > nodeCount:
+			return next - nodeCount - dataSectionSeparator
+		}
+		n = next
+	}
+	t.Fatalf("%s: 搜索树深度超过 %d", addr, bits)
+	return -1
+}
+
+func TestWriter(t *testing.T) {
+	type insert struct {
+		start, end string
+		value      string
+	}
+	ranges := []insert{
+		{"1.0.0.3", "1.0.1.7", "a"},
+		{"2.255.0.0", "3.0.255.255", "b"},
+		{"9.0.0.0", "9.255.255.255", "c"},
+		{"9.1.0.0", "9.1.0.255", "d"}, // 覆盖已有网段的一部分
+		{"255.255.255.255", "255.255.255.255", "e"},
+		{"0.0.0.0", "0.0.0.0", "a"},
+	}
+	v6ranges := []insert{
+		{"2001:db8::", "2001:db8::ffff", "f"},
+		{"ffff:ffff::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "g"},
+	}
+	tests := []struct {
+		ip, want string
+	}{
+		{"0.0.0.0", "a"},
+		{"0.0.0.1", ""},
+		{"1.0.0.2", ""},
+		{"1.0.0.3", "a"},
+		{"1.0.0.255", "a"},
+		{"1.0.1.7", "a"},
+		{"1.0.1.8", ""},
+		{"2.255.0.0", "b"},
+		{"3.0.255.255", "b"},
+		{"3.1.0.0", ""},
+		{"9.0.255.255", "c"},
+		{"9.1.0.7", "d"},
+		{"9.1.1.0", "c"},
+		{"255.255.255.255", "e"},
+	}
+	v6tests := []struct {
+		ip, want string
+	}{
+		{"2001:db8::1", "f"},
+		{"2001:db8::1:0", ""},
+		{"ffff:ffff::1", "g"},
+		{"::ffff:1.0.0.3", ""},
+	}
+	for _, ipv6 := range []bool{false, true} {
+		for _, recordSize := range []int{0, 24, 28, 32} {
+			w := NewWriter(WithRecordSize(recordSize), WithDatabaseType("test"), WithLanguages("en"), WithDescription("en", "test"))
+			for _, r := range ranges {
+				if err := w.Insert(netip.MustParseAddr(r.start), netip.MustParseAddr(r.end), r.value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if ipv6 {
+				for _, r := range v6ranges {
+					if err := w.Insert(netip.MustParseAddr(r.start), netip.MustParseAddr(r.end), r.value); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			var buf bytes.Buffer
+			if _, err := w.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			// 5 或 7 个不同的值，每个 2 字节
+			wantData := 10
+			if ipv6 {
+				wantData = 14
+			}
+			if len(w.data) != wantData {
+				t.Errorf("数据区 %d 字节，期望 %d 字节，相同的值应只写一次", len(w.data), wantData)
+			}
+			size := recordSize
+			if size == 0 {
+				size = 24
+			}
+			check := func(ip, want string) {
+				offset := lookupOffset(t, buf.Bytes(), len(w.data), size, ipv6, netip.MustParseAddr(ip))
+				got := ""
+				if offset >= 0 {
+					got = string(w.data[offset+1 : offset+2])
+				}
+				if got != want {
+					t.Errorf("ipv6=%v record=%d: %s = %q，期望 %q", ipv6, recordSize, ip, got, want)
+				}
+			}
+			for _, tt := range tests {
+				check(tt.ip, tt.want)
+			}
+			if ipv6 {
+				for _, tt := range v6tests {
+					check(tt.ip, tt.want)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	w := NewWriter()
+	if err := w.Insert(netip.MustParseAddr("1.0.0.1"), netip.MustParseAddr("1.0.0.0"), "x"); err == nil {
+		t.Error("结束 IP 小于起始 IP 应返回错误")
+	}
+	if err := w.Insert(netip.MustParseAddr("1.0.0.0"), netip.MustParseAddr("::1"), "x"); err == nil {
+		t.Error("起止 IP 版本不一致应返回错误")
+	}
+	if err := w.Insert(netip.MustParseAddr("1.0.0.0"), netip.MustParseAddr("1.0.0.1"), 1); err == nil {
+		t.Error("不支持的数据类型应返回错误")
+	}
+	w = NewWriter(WithRecordSize(20))
+	if _, err := w.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Error("不支持的记录长度应返回错误")
+	}
+}
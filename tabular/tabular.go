@@ -0,0 +1,452 @@
+// Package tabular 读取按行分列的 IP 数据文件：竖线分隔的 TXT、符合 RFC 4180 的 CSV 和制表符分隔的 TSV。
+// 各列的含义由 Schema 描述，可以用 Mapping 指定起止 IP 和各内容列所在的列，
+// 第一行为表头时按列名映射。
+package tabular
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxLineSize 输入文件单行的最大长度
+const maxLineSize = 1 << 20
+
+// sampleLines 判断分隔符时最多检查的行数
+const sampleLines = 20
+
+// 起止 IP 的列名
+const (
+	Start = "start"
+	End   = "end"
+)
+
+// startAliases、endAliases 表头中起止 IP 列可能的名称
+var (
+	startAliases = []string{"start", "start_ip", "ip_start", "startip", "ip_from", "from", "first_ip", "range_start", "begin", "start_ip_num"}
+	endAliases   = []string{"end", "end_ip", "ip_end", "endip", "ip_to", "to", "last_ip", "range_end", "finish", "end_ip_num"}
+)
+
+// Schema 描述一种数据集的内容列
+type Schema struct {
+	Fields  []string            // 内容各列的名称，按内容中的顺序
+	Aliases map[string][]string // 内容列在表头中可能的名称（小写，以下划线分隔），列名本身总是可以匹配
+	Default Mapping             // 没有表头且未指定映射时使用的列位置
+	Columns int                 // 默认格式的列数，竖线分隔时最后一列包含剩余的全部内容
+
+	// MinColumns 按默认格式读取时每行至少需要的列数，列数不足的行返回 LineError，为 0 时不检查
+	MinColumns int
+}
+
+// Mapping 列名到输入列的映射：1 开始的列序号，或表头中的列名（不区分大小写）
+type Mapping map[string]string
+
+// ParseMapping 解析 "start=1,end=2,country=country_name" 形式的列映射
+func ParseMapping(spec string) (Mapping, error) {
+	m := make(Mapping)
+	for _, item := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		item = strings.TrimSpace(item)
+		if item == "" || strings.HasPrefix(item, "#") {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("无效的列映射: %s", item)
+		}
+		m[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+	return m, nil
+}
+
+// LoadMapping 读取列映射文件，每行一项 name=column，# 开头的行为注释
+func LoadMapping(name string) (Mapping, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取列映射文件失败: %w", err)
+	}
+	return ParseMapping(string(data))
+}
+
+// Record 一行数据
+type Record struct {
+	Line       int      // 行号，从 1 开始
+	Start, End string   // 起止 IP 的原始文本
+	Fields     []string // 内容各列，顺序与 Schema.Fields 相同，缺失的列为空
+}
+
+// LineError 单行数据无法解析，跳过该行后可以继续读取
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("第 %d 行: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Reader 逐行读取数据文件
+type Reader struct {
+	schema    Schema
+	mapping   Mapping
+	explicit  bool  // 映射由调用方指定
+	byDefault bool  // 没有表头，按 Schema.Default 读取
+	columns   []int // 起止 IP 和各内容列所在的列（从 0 开始），-1 表示没有该列
+
+	pipe    *bufio.Scanner // 竖线分隔
+	csv     *csv.Reader    // CSV 或 TSV
+	line    int
+	started bool
+}
+
+// NewReader 创建 Reader。分隔符按开头若干行判断，见 detectDelimiter。
+// m 为 nil 时使用 Schema.Default 或表头自动映射
+func NewReader(r io.Reader, filename string, schema Schema, m Mapping) (*Reader, error) {
+	rd := &Reader{schema: schema, mapping: schema.Default, explicit: len(m) > 0}
+	if rd.explicit {
+		rd.mapping = m
+	}
+	for name := range rd.mapping {
+		if name != Start && name != End && schema.fieldIndex(name) < 0 {
+			return nil, fmt.Errorf("未知的列名: %s，可用的列名: %s", name, strings.Join(append([]string{Start, End}, schema.Fields...), ", "))
+		}
+	}
+	if rd.mapping[Start] == "" || rd.mapping[End] == "" {
+		return nil, errors.New("列映射缺少 start 或 end")
+	}
+
+	br := bufio.NewReaderSize(r, 1<<16)
+	lines, err := peekLines(br)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %w", err)
+	}
+	columns := schema.Columns
+	if rd.explicit {
+		columns = 0
+	}
+	switch detectDelimiter(lines, filename, columns) {
+	case '|':
+		rd.pipe = bufio.NewScanner(br)
+		rd.pipe.Buffer(make([]byte, 64*1024), maxLineSize)
+	case '\t':
+		rd.csv = newCSVReader(br, '\t')
+	default:
+		rd.csv = newCSVReader(br, ',')
+	}
+	return rd, nil
+}
+
+func newCSVReader(r io.Reader, comma rune) *csv.Reader {
+	c := csv.NewReader(r)
+	c.Comma = comma
+	c.FieldsPerRecord = -1
+	c.LazyQuotes = true
+	return c
+}
+
+// peekLines 返回缓冲区中开头最多 sampleLines 个非空行，不消耗输入。
+// 缓冲区已满时最后一行可能不完整，有其它行时不返回该行
+func peekLines(br *bufio.Reader) ([]string, error) {
+	b, err := br.Peek(br.Size())
+	full := errors.Is(err, bufio.ErrBufferFull)
+	if err != nil && err != io.EOF && !full {
+		return nil, err
+	}
+	all := strings.Split(string(b), "\n")
+	if full && len(all) > 1 {
+		all = all[:len(all)-1]
+	}
+	var lines []string
+	for _, line := range all {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+			if len(lines) == sampleLines {
+				break
+			}
+		}
+	}
+	return lines, nil
+}
+
+// detectDelimiter 按 lines 中各行的列数判断分隔符：竖线、制表符、逗号中各行列数相同且多于一列的，
+// 选择列数最多的一个，这样内容中含有逗号的竖线分隔文件不会被当作 CSV；都不满足时选择第一行中列数最多的一个；
+// 各行都只有一列时 .csv 文件按逗号、其它文件按竖线处理。列数相同时按竖线、制表符、逗号的顺序优先。
+// columns 大于 0 时竖线分隔的列数最多为 columns
+func detectDelimiter(lines []string, filename string, columns int) rune {
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	seps := []rune{'|', '\t', ','}
+	best, count := rune(0), 1
+	for _, sep := range seps {
+		n := fieldCount(lines[0], sep, columns)
+		for _, line := range lines[1:] {
+			if n <= count {
+				break
+			}
+			if fieldCount(line, sep, columns) != n {
+				n = 0
+			}
+		}
+		if n > count {
+			best, count = sep, n
+		}
+	}
+	if best != 0 {
+		return best
+	}
+	for _, sep := range seps {
+		if n := fieldCount(lines[0], sep, columns); n > count {
+			best, count = sep, n
+		}
+	}
+	if best != 0 {
+		return best
+	}
+	if strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		return ','
+	}
+	return '|'
+}
+
+// fieldCount 返回 line 按 sep 分隔的列数，制表符和逗号按 CSV 规则处理引号
+func fieldCount(line string, sep rune, columns int) int {
+	if sep == '|' {
+		n := strings.Count(line, "|") + 1
+		if columns > 0 && n > columns {
+			n = columns
+		}
+		return n
+	}
+	fields, err := newCSVReader(strings.NewReader(line), sep).Read()
+	if err != nil {
+		return 0
+	}
+	return len(fields)
+}
+
+// Read 读取下一行数据，结束时返回 io.EOF。单行无法解析时返回 *LineError，可以继续读取；
+// 映射中的列名在表头中不存在或读取文件失败时返回其它错误
+func (r *Reader) Read() (Record, error) {
+	for {
+		fields, line, err := r.next()
+		if err != nil {
+			return Record{}, err
+		}
+		if !r.started {
+			r.started = true
+			header, err := r.resolve(fields)
+			if err != nil {
+				return Record{}, err
+			}
+			r.byDefault = !r.explicit && !header
+			if header {
+				continue
+			}
+		}
+		if r.byDefault && len(fields) < r.schema.MinColumns {
+			return Record{Line: line}, &LineError{Line: line, Err: fmt.Errorf("字段不足，共 %d 列，至少需要 %d 列", len(fields), r.schema.MinColumns)}
+		}
+		rec := Record{Line: line, Fields: make([]string, len(r.schema.Fields))}
+		get := func(i int) string {
+			if c := r.columns[i]; c >= 0 && c < len(fields) {
+				return fields[c]
+			}
+			return ""
+		}
+		rec.Start, rec.End = strings.TrimSpace(get(0)), strings.TrimSpace(get(1))
+		if rec.Start == "" || rec.End == "" {
+			return rec, &LineError{Line: line, Err: fmt.Errorf("缺少起止 IP 列，共 %d 列", len(fields))}
+		}
+		for i := range rec.Fields {
+			rec.Fields[i] = get(i + 2)
+		}
+		return rec, nil
+	}
+}
+
+// next 读取下一个非空行的各列
+func (r *Reader) next() ([]string, int, error) {
+	if r.pipe != nil {
+		for r.pipe.Scan() {
+			r.line++
+			text := strings.TrimSpace(r.pipe.Text())
+			if text == "" {
+				continue
+			}
+			if r.explicit {
+				return strings.Split(text, "|"), r.line, nil
+			}
+			return strings.SplitN(text, "|", r.schema.Columns), r.line, nil
+		}
+		if err := r.pipe.Err(); err != nil {
+			return nil, r.line, fmt.Errorf("读取文件失败: %w", err)
+		}
+		return nil, r.line, io.EOF
+	}
+	for {
+		fields, err := r.csv.Read()
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			return nil, parseErr.Line, &LineError{Line: parseErr.Line, Err: parseErr.Err}
+		case err == io.EOF:
+			return nil, r.line, io.EOF
+		case err != nil:
+			return nil, r.line, fmt.Errorf("读取文件失败: %w", err)
+		}
+		r.line, _ = r.csv.FieldPos(0)
+		if len(fields) == 1 && strings.TrimSpace(fields[0]) == "" {
+			continue
+		}
+		return fields, r.line, nil
+	}
+}
+
+// resolve 根据第一行确定各列位置，第一行为表头时返回 true。
+// 映射中使用列名或第一行的起始 IP 列不是 IP 时视为表头
+func (r *Reader) resolve(first []string) (bool, error) {
+	names := append([]string{Start, End}, r.schema.Fields...)
+	r.columns = make([]int, len(names))
+	byName := false
+	for i, name := range names {
+		r.columns[i] = -1
+		ref, ok := r.mapping[name]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(ref); err == nil {
+			if n < 1 {
+				return false, fmt.Errorf("列 %s 的序号 %d 无效，列序号从 1 开始", name, n)
+			}
+			r.columns[i] = n - 1
+		} else {
+			byName = true
+		}
+	}
+
+	header := byName
+	if !header {
+		if c := r.columns[0]; c >= len(first) || !LooksLikeIP(first[c]) {
+			header = true
+		}
+	}
+	if !header {
+		return false, nil
+	}
+
+	index := make(map[string]int)
+	for i, h := range first {
+		key := normalizeName(h)
+		if _, exists := index[key]; !exists {
+			index[key] = i
+		}
+	}
+	if r.explicit {
+		for i, name := range names {
+			ref, ok := r.mapping[name]
+			if _, err := strconv.Atoi(ref); !ok || err == nil {
+				continue
+			}
+			c, found := index[normalizeName(ref)]
+			if !found {
+				return true, fmt.Errorf("表头中没有列 %s（%s）", ref, name)
+			}
+			r.columns[i] = c
+		}
+		return true, nil
+	}
+
+	// 未指定映射时按表头中的列名自动映射，起止 IP 列无法识别时按数据行处理，
+	// 由调用方报告该行无法解析
+	defaults := append([]int(nil), r.columns...)
+	for i, name := range names {
+		r.columns[i] = -1
+		aliases := r.schema.Aliases[name]
+		switch name {
+		case Start:
+			aliases = startAliases
+		case End:
+			aliases = endAliases
+		}
+		for _, alias := range append([]string{name}, aliases...) {
+			if c, found := index[alias]; found {
+				r.columns[i] = c
+				break
+			}
+		}
+	}
+	if r.columns[0] < 0 || r.columns[1] < 0 {
+		r.columns = defaults
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s Schema) fieldIndex(name string) int {
+	for i, f := range s.Fields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeName 列名转为小写，空格和连字符替换为下划线，去掉 UTF-8 BOM
+func normalizeName(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "\ufeff")
+	s = strings.ToLower(s)
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+}
+
+// LooksLikeIP 判断 s 是否为 IP 文本或十进制数值形式的 IP
+func LooksLikeIP(s string) bool {
+	s = strings.TrimSpace(strings.TrimPrefix(s, "\ufeff"))
+	if _, err := netip.ParseAddr(s); err == nil {
+		return true
+	}
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseIP 解析 IP 文本或十进制整数形式的 IP，IPv4 映射地址转为 IPv4。
+// 数值超过 32 位或 ipv6 为 true 时按 IPv6 处理
+func ParseIP(s string, ipv6 bool) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, ".:") {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Addr{}, err
+		}
+		return ip.Unmap().WithZone(""), nil
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return netip.Addr{}, fmt.Errorf("无效的 IP 数值: %s", s)
+	}
+	if ipv6 || n.BitLen() > 32 {
+		var b [16]byte
+		n.FillBytes(b[:])
+		return netip.AddrFrom16(b), nil
+	}
+	var b [4]byte
+	n.FillBytes(b[:])
+	return netip.AddrFrom4(b), nil
+}
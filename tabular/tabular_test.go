@@ -0,0 +1,216 @@
+package tabular
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	Fields:  []string{"range", "asn", "org"},
+	Aliases: map[string][]string{"org": {"organization"}},
+	Default: Mapping{"start": "1", "end": "2", "range": "3", "asn": "4", "org": "5"},
+	Columns: 5,
+}
+
+func readAll(t *testing.T, input, filename string, m Mapping) ([]Record, []int) {
+	t.Helper()
+	r, err := NewReader(strings.NewReader(input), filename, testSchema, m)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	var records []Record
+	var bad []int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records, bad
+		}
+		var lineErr *LineError
+		if errors.As(err, &lineErr) {
+			bad = append(bad, lineErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		filename string
+		mapping  Mapping
+		want     []Record
+		bad      []int
+	}{
+		{
+			name:  "pipe",
+			input: "1|2|1.0.0.0/24|13335|Org|With|Pipes\n\n  \n3|4|-|-|-\n",
+			want: []Record{
+				{Line: 1, Start: "1", End: "2", Fields: []string{"1.0.0.0/24", "13335", "Org|With|Pipes"}},
+				{Line: 4, Start: "3", End: "4", Fields: []string{"-", "-", "-"}},
+			},
+		},
+		{
+			name:  "csv quoted",
+			input: "\"1\",\"2\",\"1.0.0.0/24\",\"13335\",\"CloudFlare, Inc. \"\"CF\"\"\"\r\n\"3\",\"4\",\"-\",\"-\",\"multi\nline\"\n",
+			want: []Record{
+				{Line: 1, Start: "1", End: "2", Fields: []string{"1.0.0.0/24", "13335", `CloudFlare, Inc. "CF"`}},
+				{Line: 2, Start: "3", End: "4", Fields: []string{"-", "-", "multi\nline"}},
+			},
+		},
+		{
+			name:  "tsv",
+			input: "1.0.0.0\t1.0.0.255\t1.0.0.0/24\t13335\tOrg, Inc.\n",
+			want:  []Record{{Line: 1, Start: "1.0.0.0", End: "1.0.0.255", Fields: []string{"1.0.0.0/24", "13335", "Org, Inc."}}},
+		},
+		{
+			name:  "header auto mapping",
+			input: "\ufeffOrganization,ASN,IP To,IP From\n\"Org, Inc.\",13335,1.0.0.255,1.0.0.0\n",
+			want:  []Record{{Line: 2, Start: "1.0.0.0", End: "1.0.0.255", Fields: []string{"", "13335", "Org, Inc."}}},
+		},
+		{
+			name:    "mapping by name",
+			input:   "a,b,c,d\n1.0.0.0,1.0.0.255,x,AS1\n",
+			mapping: Mapping{"start": "A", "end": "b", "asn": "d", "org": "3"},
+			want:    []Record{{Line: 2, Start: "1.0.0.0", End: "1.0.0.255", Fields: []string{"", "AS1", "x"}}},
+		},
+		{
+			name:    "mapping by number skips header",
+			input:   "org|asn|from|to\nOrg|13335|1|2\n",
+			mapping: Mapping{"start": "3", "end": "4", "asn": "2", "org": "1"},
+			want:    []Record{{Line: 2, Start: "1", End: "2", Fields: []string{"", "13335", "Org"}}},
+		},
+		{
+			// 无法按表头映射的第一行按数据返回，由调用方校验 IP
+			name:  "bad first line is not a header",
+			input: "garbage|x|y|z|w\n1|2|r|a|o\n5\n",
+			want: []Record{
+				{Line: 1, Start: "garbage", End: "x", Fields: []string{"y", "z", "w"}},
+				{Line: 2, Start: "1", End: "2", Fields: []string{"r", "a", "o"}},
+			},
+			bad: []int{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tt.filename
+			if filename == "" {
+				filename = "input.txt"
+			}
+			records, bad := readAll(t, tt.input, filename, tt.mapping)
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Errorf("records = %q，期望 %q", records, tt.want)
+			}
+			if !reflect.DeepEqual(bad, tt.bad) {
+				t.Errorf("无法解析的行 = %v，期望 %v", bad, tt.bad)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(strings.NewReader(""), "a.txt", testSchema, Mapping{"start": "1", "end": "2", "country": "3"}); err == nil {
+		t.Error("未知的列名应返回错误")
+	}
+	if _, err := NewReader(strings.NewReader(""), "a.txt", testSchema, Mapping{"start": "1"}); err == nil {
+		t.Error("缺少 end 应返回错误")
+	}
+	r, err := NewReader(strings.NewReader("a,b\n1,2\n"), "a.csv", testSchema, Mapping{"start": "a", "end": "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lineErr *LineError
+	if _, err := r.Read(); err == nil || errors.As(err, &lineErr) {
+		t.Errorf("表头中没有的列应返回错误，实际 %v", err)
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping("start=1, End = ip_to\n# comment\norg=Organization Name\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Mapping{"start": "1", "end": "ip_to", "org": "Organization Name"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("ParseMapping = %v，期望 %v", m, want)
+	}
+	if _, err := ParseMapping("start"); err == nil {
+		t.Error("缺少 = 应返回错误")
+	}
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		lines    []string
+		filename string
+		want     rune
+	}{
+		{[]string{"1|2|3|4|5"}, "a.csv", '|'},
+		{[]string{"1\t2\t3\t4\t5"}, "a.txt", '\t'},
+		{[]string{`"1","2","3","4","5"`}, "a.txt", ','},
+		{[]string{"1|2|3|Org, Inc.|x"}, "a.txt", '|'},
+		{[]string{"1,2"}, "a.CSV", ','},
+		{[]string{"1"}, "a.CSV", ','},
+		{[]string{"1"}, "a.txt", '|'},
+		{nil, "a.txt", '|'},
+		// 竖线分隔、内容中逗号比竖线多
+		{[]string{"1.0.0.0|1.0.0.255|13335|Cloudflare, Inc., US, CA"}, "a.txt", '|'},
+		{[]string{
+			"1.0.0.0|1.0.0.255|1.0.0.0/24|13335|Cloudflare, Inc., US, CA, San Francisco, 94107",
+			"1.0.1.0|1.0.1.255|1.0.1.0/24|0|Not routed",
+		}, "a.txt", '|'},
+		// 组织名称中的竖线不影响列数
+		{[]string{"1|2|1.0.0.0/24|13335|A|B", "3|4|-|-|-"}, "a.txt", '|'},
+		// CSV 中引号内的逗号和竖线
+		{[]string{`"1","2","1.0.0.0/24","13335","Cloudflare, Inc."`, `"3","4","-","-","a|b"`}, "a.txt", ','},
+		{[]string{`"1","2","3","4","a|b"`}, "a.txt", ','},
+		// 各行列数都不一致时按第一行列数最多的判断
+		{[]string{"a,b,c|d", "e"}, "a.txt", ','},
+	}
+	for _, tt := range tests {
+		if got := detectDelimiter(tt.lines, tt.filename, testSchema.Columns); got != tt.want {
+			t.Errorf("detectDelimiter(%q, %q) = %q，期望 %q", tt.lines, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestMinColumns(t *testing.T) {
+	schema := testSchema
+	schema.MinColumns = 5
+	r, err := NewReader(strings.NewReader("1|2|3\n1|2|1.0.0.0/24|13335|\n1|2|1.0.0.0/24|13335\n"), "a.txt", schema, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines, bad []int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var lineErr *LineError
+		if errors.As(err, &lineErr) {
+			bad = append(bad, lineErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, rec.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{2}) || !reflect.DeepEqual(bad, []int{1, 3}) {
+		t.Errorf("读取的行 %v，无法解析的行 %v", lines, bad)
+	}
+
+	// 指定映射时不检查列数
+	records, bad := readAll(t, "1|2|3\n", "a.txt", Mapping{"start": "1", "end": "2", "range": "3"})
+	if len(records) != 1 || len(bad) != 0 {
+		t.Errorf("指定映射时 %v，无法解析的行 %v", records, bad)
+	}
+}